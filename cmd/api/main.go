@@ -10,31 +10,59 @@ import (
 	"time"
 
 	"cadastral-service/internal/api"
+	"cadastral-service/internal/cache"
 	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/repository"
+	"cadastral-service/internal/repository/memory"
+	"cadastral-service/internal/service"
 	"cadastral-service/pkg/database"
 	"cadastral-service/pkg/logger"
 
 	"github.com/gin-gonic/gin"
-	"honnef.co/go/tools/config"
 )
 
 func main() {
 	//load config
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
 
 	//initialization logger
 	logger.Init(cfg.LogLevel)
 
-	//init data base
-	db, err := database.NewPostgres(cfg.DatabaseURL)
-	if err != nil {
-		log.Fatal("Failed to connect to database: %v", err)
+	//migrate subcommand runs instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
-	defer db.Close()
 
-	//run migration
-	if err := database.RunMigrations(cfg.DatabaseURL); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	//init storage, the backend is picked by the DATABASE_URL scheme
+	var repo repository.Store
+	if cfg.DatabaseDriver == config.DriverMemory {
+		log.Println("Using in-memory storage, data is lost on exit")
+		repo = memory.New()
+	} else {
+		db, err := openDatabase(cfg)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer db.Close()
+
+		//run pending migrations
+		if err := database.Migrate(context.Background(), db, cfg.DatabaseDriver); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		if cfg.DatabaseDriver == config.DriverSQLite {
+			repo = repository.NewSQLiteRepository(db)
+		} else {
+			repo = repository.NewRepository(db)
+		}
 	}
 
 	//init Gin
@@ -49,8 +77,25 @@ func main() {
 	router.Use(gin.Recovery())
 	router.Use(CORSMiddleware())
 
+	//init providers that check requests
+	providers, err := provider.FromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to init providers: %v", err)
+	}
+
+	//init result cache, off unless CACHE_TTL is set
+	results, err := cache.New(cfg.Cache)
+	if err != nil {
+		log.Fatalf("Failed to init result cache: %v", err)
+	}
+
+	//init query workers, they resume requests left by a previous run
+	hub := events.NewHub()
+	svc := service.NewService(repo, hub, cfg, providers, results)
+	svc.Start(context.Background())
+
 	//init handlers
-	handler := api.NewHandler(db, cfg)
+	handler := api.NewHandler(repo, svc, cfg)
 	api.SetupRoutes(router, handler, cfg)
 
 	//run server
@@ -58,6 +103,8 @@ func main() {
 		Addr:    ":" + cfg.Port,
 		Handler: router,
 	}
+	//long polls and event streams return when shutdown begins
+	srv.RegisterOnShutdown(hub.Close)
 
 	//graceful shutdown
 	go func() {
@@ -79,9 +126,13 @@ func main() {
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	//stop workers, unfinished requests go back to the queue, also after a
+	//forced shutdown
+	svc.Stop()
+
 	log.Println("Server exiting")
 }
 
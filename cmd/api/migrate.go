@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"cadastral-service/internal/config"
+	"cadastral-service/pkg/database"
+)
+
+const migrateUsage = "usage: api migrate up | down [steps] | status | goto <version>"
+
+// openDatabase is connect to the SQL database of DATABASE_URL
+func openDatabase(cfg *config.Config) (*sql.DB, error) {
+	if cfg.DatabaseDriver == config.DriverSQLite {
+		return database.NewSQLite(cfg.DatabaseURL)
+	}
+	return database.NewPostgres(cfg.DatabaseURL)
+}
+
+// runMigrate is the migrate subcommand: up applies pending migrations, down
+// rolls back the last steps (one by default), goto moves to a version and
+// status prints what is applied
+func runMigrate(cfg *config.Config, args []string) error {
+	if cfg.DatabaseDriver == config.DriverMemory {
+		return fmt.Errorf("in-memory storage has no schema to migrate")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db, cfg.DatabaseDriver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number")
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("version must be a number")
+		}
+		return migrator.Goto(ctx, version)
+	case "status":
+		return printMigrationStatus(ctx, migrator)
+	}
+	return errors.New(migrateUsage)
+}
+
+func printMigrationStatus(ctx context.Context, migrator *database.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if status.Modified {
+			state = "modified"
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
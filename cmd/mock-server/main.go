@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
@@ -9,19 +12,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"cadastral-service/internal/provider"
+	"cadastral-service/pkg/idgen"
 )
 
 type Request struct {
 	CadastralNumber string  `json:"cadastral_number"`
 	Latitude        float64 `json:"latitude"`
 	Longitude       float64 `json:"longitude"`
+	// CallbackURL switches to the callback mode: the request is answered
+	// with 202 and a job id, the result is POSTed there later
+	CallbackURL string `json:"callback_url"`
 }
 
 type Response struct {
+	JobID  string  `json:"job_id,omitempty"`
 	Result bool    `json:"result"`
 	Delay  float64 `json:"delay"`
 }
 
+// callbackAttempts is how many times a callback is tried before giving up
+const callbackAttempts = 5
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -38,6 +51,9 @@ func main() {
 		}
 	}
 
+	// callbackSecret signs callbacks, the service knows it as callback_secret of the provider
+	callbackSecret := os.Getenv("CALLBACK_SECRET")
+
 	router := gin.Default()
 
 	router.POST("/api/result", func(c *gin.Context) {
@@ -51,6 +67,20 @@ func main() {
 
 		// imitate a proccess (from 1 to delayMax seconds)
 		delay := rand.Intn(delayMax) + 1
+
+		if req.CallbackURL != "" {
+			jobID := idgen.New()
+			go callBack(req.CallbackURL, callbackSecret, Response{
+				JobID:  jobID,
+				Result: rand.Intn(2) == 1,
+				Delay:  float64(delay),
+			})
+
+			log.Printf("Accepted job %s, calling back in %ds", jobID, delay)
+			c.JSON(http.StatusAccepted, gin.H{"job_id": jobID})
+			return
+		}
+
 		time.Sleep(time.Duration(delay) * time.Second)
 
 		// random result
@@ -73,3 +103,42 @@ func main() {
 	log.Printf("Mock server starting on port %s", port)
 	log.Fatal(router.Run(":" + port))
 }
+
+// callBack is deliver the result of a job after its delay, failed
+// deliveries are tried again with a growing pause
+func callBack(url, secret string, response Response) {
+	time.Sleep(time.Duration(response.Delay) * time.Second)
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Failed to encode callback of job %s: %v", response.JobID, err)
+		return
+	}
+
+	pause := time.Second
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to build callback of job %s: %v", response.JobID, err)
+			return
+		}
+		timestamp := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(provider.CallbackTimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(provider.CallbackSignatureHeader, provider.SignCallback(secret, timestamp, body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				log.Printf("Delivered job %s: result=%v", response.JobID, response.Result)
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+
+		log.Printf("Callback of job %s attempt %d failed: %v", response.JobID, attempt, err)
+		time.Sleep(pause)
+		pause *= 2
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+	"cadastral-service/pkg/idgen"
+)
+
+// maxBatchSize limits items of one POST /queries/batch
+const maxBatchSize = 1000
+
+// BatchItemResult is the outcome of one item, in the order of the request
+type BatchItemResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	BatchID  string            `json:"batch_id,omitempty"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Items    []BatchItemResult `json:"items"`
+	// CallbackSecret signs deliveries to every callback_url of the batch
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+type BatchProgressResponse struct {
+	BatchID   string         `json:"batch_id"`
+	Total     int            `json:"total"`
+	Finished  int            `json:"finished"`
+	Progress  float64        `json:"progress"`
+	Done      bool           `json:"done"`
+	Statuses  map[string]int `json:"statuses"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// CreateBatch is submit an array of requests, every item is validated on
+// its own and the valid ones are saved together
+func (h *Handler) CreateBatch(c *gin.Context) {
+	// a huge body is cut before it is decoded into memory
+	h.limitBody(c)
+
+	var items []QueryRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&items); err != nil {
+		if !h.bodyTooLarge(c, err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON array of queries"})
+		}
+		return
+	}
+
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "batch is empty"})
+		return
+	}
+	if len(items) > maxBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("batch is limited to %d queries", maxBatchSize)})
+		return
+	}
+
+	userID := currentUserID(c)
+	batch := &models.Batch{
+		ID:        idgen.New(),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+
+	response := BatchResponse{Items: make([]BatchItemResult, len(items))}
+	var queries []*models.Query
+	for i := range items {
+		response.Items[i].Index = i
+		if err := h.validateQueryRequest(&items[i]); err != nil {
+			response.Items[i].Error = err.Error()
+			response.Rejected++
+			continue
+		}
+
+		query := newQuery(&items[i], userID)
+		query.BatchID = batch.ID
+		queries = append(queries, query)
+		response.Items[i].ID = query.ID
+		response.Accepted++
+	}
+
+	if len(queries) == 0 {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	batch.Total = len(queries)
+	secret := signCallbacks(queries...)
+	if err := h.service.SubmitBatch(c.Request.Context(), batch, queries); err != nil {
+		h.submitError(c, err)
+		return
+	}
+
+	response.BatchID = batch.ID
+	response.CallbackSecret = secret
+	c.JSON(http.StatusAccepted, response)
+}
+
+// GetBatch is return aggregate progress of a batch
+func (h *Handler) GetBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	batch, err := h.repo.GetBatch(ctx, c.Param("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "batch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get batch"})
+		return
+	}
+
+	if userID := currentUserID(c); userID != "" && batch.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "batch not found"})
+		return
+	}
+
+	statuses, err := h.repo.CountBatchStatuses(ctx, batch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get batch progress"})
+		return
+	}
+
+	response := BatchProgressResponse{
+		BatchID:   batch.ID,
+		Total:     batch.Total,
+		Statuses:  statuses,
+		CreatedAt: batch.CreatedAt,
+	}
+	for status, count := range statuses {
+		if models.IsTerminalStatus(status) {
+			response.Finished += count
+		}
+	}
+	if batch.Total > 0 {
+		response.Progress = float64(response.Finished) / float64(batch.Total)
+	}
+	response.Done = response.Finished >= batch.Total
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCallbackSize caps the body of a provider callback
+const maxCallbackSize = 1 << 20
+
+// ProviderCallback is receive the verdict of a provider answering by
+// callback, it is authenticated by the signature of the shared secret
+func (h *Handler) ProviderCallback(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCallbackSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read callback"})
+		return
+	}
+
+	err = h.service.HandleCallback(c.Request.Context(), c.Param("provider"), c.Request.Header, body)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"status": "accepted"})
+	case errors.Is(err, service.ErrNotCallbackProvider):
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+	case errors.Is(err, provider.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+	case errors.Is(err, service.ErrInvalidCallback):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply callback"})
+	}
+}
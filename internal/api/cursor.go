@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+// HistoryPage is the envelope of history in cursor mode
+type HistoryPage struct {
+	Items      []QueryResponse `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	PrevCursor string          `json:"prev_cursor,omitempty"`
+	// Total is set with ?include_total=true
+	Total *int `json:"total,omitempty"`
+}
+
+// cursorToken is the content of an opaque cursor, clients must not rely on it
+type cursorToken struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Before    bool      `json:"b,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(q *models.Query, before bool) string {
+	data, _ := json.Marshal(cursorToken{CreatedAt: q.CreatedAt, ID: q.ID, Before: before})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor is parse a cursor, an empty one is the first page
+func decodeCursor(cursor string) (*repository.Keyset, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var token cursorToken
+	if err := json.Unmarshal(data, &token); err != nil || token.ID == "" || token.CreatedAt.IsZero() {
+		return nil, errInvalidCursor
+	}
+
+	return &repository.Keyset{CreatedAt: token.CreatedAt, ID: token.ID, Before: token.Before}, nil
+}
+
+// pageCursors is cursors around a page. Coming from a cursor means there are
+// items on its side; in the walking direction more says it.
+func pageCursors(queries []models.Query, key *repository.Keyset, more bool) (next, prev string) {
+	if len(queries) == 0 {
+		return "", ""
+	}
+	first, last := &queries[0], &queries[len(queries)-1]
+
+	hasNext, hasPrev := more, key != nil
+	if key != nil && key.Before {
+		hasNext, hasPrev = true, more
+	}
+
+	if hasNext {
+		next = encodeCursor(last, false)
+	}
+	if hasPrev {
+		prev = encodeCursor(first, true)
+	}
+	return next, prev
+}
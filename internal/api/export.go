@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+// content types of the export formats
+const (
+	mimeCSV     = "text/csv"
+	mimeGeoJSON = "application/geo+json"
+	mimeXLSX    = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportFlushEvery is how many rows are written between flushes to the client
+const exportFlushEvery = 500
+
+var exportHeader = []string{
+	"id", "cadastral_number", "latitude", "longitude", "status", "result",
+	"attempts", "last_error", "created_at", "completed_at",
+}
+
+// ExportHistory is stream history of the current user as CSV, GeoJSON or
+// XLSX. The format comes from ?format= or the Accept header, CSV by default.
+func (h *Handler) ExportHistory(c *gin.Context) {
+	format := exportFormat(c.Query("format"), c.GetHeader("Accept"))
+	if format == "" {
+		c.JSON(http.StatusNotAcceptable, gin.H{"error": "format must be csv, geojson or xlsx"})
+		return
+	}
+
+	filter, err := historyFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := "history-" + time.Now().UTC().Format("20060102-150405")
+
+	switch format {
+	case "csv":
+		setAttachment(c, mimeCSV+"; charset=utf-8", filename+".csv")
+		err = h.exportCSV(c, filter)
+	case "geojson":
+		setAttachment(c, mimeGeoJSON, filename+".geojson")
+		err = h.exportGeoJSON(c, filter)
+	case "xlsx":
+		setAttachment(c, mimeXLSX, filename+".xlsx")
+		err = h.exportXLSX(c, filter)
+	}
+
+	// headers are already sent, the client sees a truncated file
+	if err != nil {
+		log.Printf("Failed to export history: %v", err)
+		c.Abort()
+	}
+}
+
+// exportFormat is pick the format by ?format or the Accept header
+func exportFormat(format, accept string) string {
+	if format != "" {
+		switch strings.ToLower(format) {
+		case "csv":
+			return "csv"
+		case "geojson", "json":
+			return "geojson"
+		case "xlsx":
+			return "xlsx"
+		}
+		return ""
+	}
+
+	if accept == "" {
+		return "csv"
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case mimeCSV, "*/*", "text/*":
+			return "csv"
+		case mimeGeoJSON, "application/json":
+			return "geojson"
+		case mimeXLSX:
+			return "xlsx"
+		}
+	}
+	return ""
+}
+
+func setAttachment(c *gin.Context, contentType, filename string) {
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+}
+
+// exportRecord is one request as text cells, shared by CSV and XLSX
+func exportRecord(q *models.Query) []string {
+	result := ""
+	if q.Result != nil {
+		result = strconv.FormatBool(*q.Result)
+	}
+	completedAt := ""
+	if q.CompletedAt != nil {
+		completedAt = q.CompletedAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		q.ID,
+		q.CadastralNumber,
+		strconv.FormatFloat(q.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(q.Longitude, 'f', -1, 64),
+		q.Status,
+		result,
+		strconv.Itoa(q.Attempts),
+		q.LastError,
+		q.CreatedAt.UTC().Format(time.RFC3339),
+		completedAt,
+	}
+}
+
+func (h *Handler) exportCSV(c *gin.Context, filter repository.QueryFilter) error {
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(exportHeader); err != nil {
+		return err
+	}
+
+	n := 0
+	err := h.repo.StreamQueries(c.Request.Context(), filter, func(q *models.Query) error {
+		if err := w.Write(exportRecord(q)); err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+		return w.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties QueryResponse   `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// exportGeoJSON is write a FeatureCollection feature by feature,
+// GeoJSON points are [longitude, latitude]
+func (h *Handler) exportGeoJSON(c *gin.Context, filter repository.QueryFilter) error {
+	if _, err := io.WriteString(c.Writer, `{"type":"FeatureCollection","features":[`); err != nil {
+		return err
+	}
+
+	n := 0
+	err := h.repo.StreamQueries(c.Request.Context(), filter, func(q *models.Query) error {
+		feature, err := json.Marshal(geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{
+				Type:        "Point",
+				Coordinates: [2]float64{q.Longitude, q.Latitude},
+			},
+			Properties: newQueryResponse(q),
+		})
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			if _, err := io.WriteString(c.Writer, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := c.Writer.Write(feature); err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(c.Writer, "]}")
+	return err
+}
+
+// exportXLSX is write rows through excelize stream writer, which keeps
+// only a window of rows in memory and spills the rest to a temporary file
+func (h *Handler) exportXLSX(c *gin.Context, filter repository.QueryFilter) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := f.GetSheetName(0)
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	rowNumber := 1
+	writeRow := func(values []string) error {
+		cells := make([]interface{}, len(values))
+		for i, v := range values {
+			cells[i] = v
+		}
+		cell, err := excelize.CoordinatesToCellName(1, rowNumber)
+		if err != nil {
+			return err
+		}
+		rowNumber++
+		return sw.SetRow(cell, cells)
+	}
+
+	if err := writeRow(exportHeader); err != nil {
+		return err
+	}
+
+	err = h.repo.StreamQueries(c.Request.Context(), filter, func(q *models.Query) error {
+		return writeRow(exportRecord(q))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(c.Writer)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"cadastral-service/internal/cadastral"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+// dateLayout is accepted by the range parameters besides RFC 3339
+const dateLayout = "2006-01-02"
+
+// historyFilter is conditions of history listing and export taken from the
+// request: status, result, created_from/created_to, completed_from/completed_to,
+// cadastral_prefix, sort and order
+func historyFilter(c *gin.Context) (repository.QueryFilter, error) {
+	// take user ID from context if auth exist
+	filter := repository.QueryFilter{UserID: currentUserID(c)}
+
+	if value := c.Query("status"); value != "" {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.TrimSpace(status)
+			if !validStatus(status) {
+				return filter, fmt.Errorf("unknown status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	switch result := c.Query("result"); result {
+	case repository.ResultAny, repository.ResultTrue, repository.ResultFalse, repository.ResultNull:
+		filter.Result = result
+	default:
+		return filter, fmt.Errorf("result must be true, false or null")
+	}
+
+	if prefix := strings.TrimSpace(c.Query("cadastral_prefix")); prefix != "" {
+		// 77:1: is the area 01 of stored numbers, 77:1 any area starting with 1
+		normalized, err := cadastral.NormalizePrefix(prefix)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cadastral_prefix: %w", err)
+		}
+		filter.CadastralPrefix = normalized
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseRangeTime(c, "created_from", false); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = parseRangeTime(c, "created_to", true); err != nil {
+		return filter, err
+	}
+	if filter.CompletedFrom, err = parseRangeTime(c, "completed_from", false); err != nil {
+		return filter, err
+	}
+	if filter.CompletedTo, err = parseRangeTime(c, "completed_to", true); err != nil {
+		return filter, err
+	}
+
+	filter.Sort = c.DefaultQuery("sort", "created_at")
+	if !repository.IsSortField(filter.Sort) {
+		return filter, fmt.Errorf("sort must be created_at, completed_at, status, cadastral_number or attempts")
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		filter.Desc = true
+	case "asc":
+	default:
+		return filter, fmt.Errorf("order must be asc or desc")
+	}
+
+	return filter, nil
+}
+
+// parseRangeTime is read a range bound as RFC 3339 or a date. A date as the
+// upper bound includes the whole day.
+func parseRangeTime(c *gin.Context, name string, upper bool) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", name)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case models.StatusPending, models.StatusProcessing, models.StatusCompleted,
+		models.StatusFailed, models.StatusDeadLetter, models.StatusCancelled, models.StatusTimedOut:
+		return true
+	}
+	return false
+}
@@ -1,42 +1,82 @@
 package api
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 
+	"cadastral-service/internal/cadastral"
 	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
 	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
 	"cadastral-service/internal/repository"
 	"cadastral-service/internal/service"
+	"cadastral-service/pkg/idgen"
 )
 
+// maxLongPollWait caps the wait parameter of GET /query/:id
+const maxLongPollWait = 60 * time.Second
+
 type Handler struct {
-	repo    *repository.Repository
+	repo    repository.Store
 	service *service.Service
-	config  *config.Config
+	Config  *config.Config
 }
 
 type QueryRequest struct {
 	CadastralNumber string  `json:"cadastral_number" binding:"required"`
 	Latitude        float64 `json:"latitude" binding:"required"`
 	Longitude       float64 `json:"longitude" binding:"required"`
+	// CallbackURL receives a signed POST when the request is finished
+	CallbackURL string `json:"callback_url"`
+	// Provider checks the request, the default one when empty
+	Provider string `json:"provider"`
+	// Providers check the request together, their answers are combined by
+	// Strategy (first-wins, majority, unanimous or all-must-agree)
+	Providers []string `json:"providers"`
+	Strategy  string   `json:"strategy"`
+	// Timeout is the processing deadline like 30s, the default one when empty
+	Timeout string `json:"timeout"`
+	// RetryOnTimeout tells whether the request is retried after its deadline
+	// passed, the default policy when absent
+	RetryOnTimeout *bool `json:"retry_on_timeout"`
 }
 
 type QueryResponse struct {
-	ID              string    `json:"id"`
-	CadastralNumber string    `json:"cadastral_number"`
-	Latitude        float64   `json:"latitude"`
-	Longitude       float64   `json:"longitude"`
-	Status          string    `json:"status"`
-	Result          *bool     `json:"result,omitempty"`
-	CreatedAt       time.Time `json:"created_at"`
-	CompletedAt     time.Time `json:"completed_at,omitempty"`
+	ID              string            `json:"id"`
+	CadastralNumber string            `json:"cadastral_number"`
+	Cadastral       *cadastral.Number `json:"cadastral,omitempty"`
+	Latitude        float64           `json:"latitude"`
+	Longitude       float64           `json:"longitude"`
+	Status          string            `json:"status"`
+	Result          *bool             `json:"result,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	CompletedAt     *time.Time        `json:"completed_at,omitempty"`
+	Attempts        int               `json:"attempts"`
+	LastError       string            `json:"last_error,omitempty"`
+	NextAttemptAt   *time.Time        `json:"next_attempt_at,omitempty"`
+	Cached          bool              `json:"cached"`
+	Provider        string            `json:"provider,omitempty"`
+	Providers       []string          `json:"providers,omitempty"`
+	Strategy        string            `json:"strategy,omitempty"`
+	Disagreement    bool              `json:"disagreement,omitempty"`
+	Timeout         string            `json:"timeout,omitempty"`
+	RetryOnTimeout  bool              `json:"retry_on_timeout"`
+	// CallbackSecret signs deliveries to callback_url, it is only in the
+	// answer to POST /query
+	CallbackSecret string `json:"callback_secret,omitempty"`
+	// Answers of every provider, only in GET /query/:id of a fanned out request
+	Answers []models.ProviderAnswer `json:"answers,omitempty"`
 }
 
 type LoginRequest struct {
@@ -48,13 +88,11 @@ type LoginResponse struct {
 	Token string `json:"token"`
 }
 
-func NewHandler(db *sql.DB, cfg *config.Config) *Handler {
-	repo := repository.NewRepository(db)
-	svc := service.NewService(repo, cfg)
+func NewHandler(repo repository.Store, svc *service.Service, cfg *config.Config) *Handler {
 	return &Handler{
 		repo:    repo,
 		service: svc,
-		config:  cfg,
+		Config:  cfg,
 	}
 }
 
@@ -75,78 +113,214 @@ func (h *Handler) CreateQuery(c *gin.Context) {
 	}
 
 	// data validataion
-	if req.CadastralNumber == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cadastral_number is required"})
+	if err := h.validateQueryRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if req.Latitude < -90 || req.Latitude > 90 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "latitude must be between -90 and 90"})
+	// make request, user ID is taken from context if auth exist
+	query := newQuery(&req, currentUserID(c))
+	secret := signCallbacks(query)
+
+	// save request in the queue, workers will process it
+	if err := h.service.Submit(c.Request.Context(), query); err != nil {
+		h.submitError(c, err)
 		return
 	}
 
+	// return answer, the only time the secret is shown
+	response := newQueryResponse(query)
+	response.CallbackSecret = secret
+	c.JSON(http.StatusAccepted, response)
+}
+
+// validateQueryRequest is check one request, batches and imports use the same rules
+func (h *Handler) validateQueryRequest(req *QueryRequest) error {
+	if req.CadastralNumber == "" {
+		return errors.New("cadastral_number is required")
+	}
+
+	// store every number in one normalized form
+	number, err := cadastral.Normalize(req.CadastralNumber)
+	if err != nil {
+		return err
+	}
+	req.CadastralNumber = number
+
+	if req.Latitude < -90 || req.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+
 	if req.Longitude < -180 || req.Longitude > 180 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "longitude must be between -180 and 180"})
-		return
+		return errors.New("longitude must be between -180 and 180")
 	}
 
-	// taken user ID from context if auth exist
-	var userID string
-	if claims, exists := c.Get("userClaims"); exists {
-		if userClaims, ok := claims.(*Claims); ok {
-			userID = userClaims.UserID
+	if req.CallbackURL != "" && !h.validCallbackURL(req.CallbackURL) {
+		return errors.New("callback_url must be an absolute http(s) URL of a public host")
+	}
+
+	if err := h.validateDeadline(req); err != nil {
+		return err
+	}
+
+	if len(req.Providers) > 0 {
+		return h.validateFanOut(req)
+	}
+	if req.Strategy != "" {
+		return errors.New("strategy needs a list of providers")
+	}
+
+	// record the provider that will check it, also when it is the default
+	name, ok := h.service.ResolveProvider(req.Provider)
+	if !ok {
+		return fmt.Errorf("unknown provider %q", req.Provider)
+	}
+	req.Provider = name
+
+	return nil
+}
+
+// validateDeadline is check the timeout of a request and fill in the retry
+// policy of timed out requests
+func (h *Handler) validateDeadline(req *QueryRequest) error {
+	if req.Timeout != "" {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 {
+			return errors.New("timeout must be a positive duration like 30s")
+		}
+		if max := h.maxTimeout(); max > 0 && timeout > max {
+			return fmt.Errorf("timeout must not exceed %s", max)
+		}
+	}
+
+	if req.RetryOnTimeout == nil {
+		retry := h.Config.Queue.RetryOnTimeout
+		req.RetryOnTimeout = &retry
+	}
+
+	return nil
+}
+
+// maxTimeout is the longest deadline a request may ask for, a deadline past
+// the lease would let another worker claim the request meanwhile
+func (h *Handler) maxTimeout() time.Duration {
+	max := h.Config.Queue.MaxTimeout
+	if lease := h.Config.Queue.LeaseDuration; lease > 0 && (max <= 0 || max > lease) {
+		max = lease
+	}
+	return max
+}
+
+// validateFanOut is check providers and strategy of a request checked by several providers
+func (h *Handler) validateFanOut(req *QueryRequest) error {
+	if req.Provider != "" {
+		return errors.New("provider and providers cannot be used together")
+	}
+
+	seen := make(map[string]bool, len(req.Providers))
+	for _, name := range req.Providers {
+		if _, ok := h.service.ResolveProvider(name); name == "" || !ok {
+			return fmt.Errorf("unknown provider %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("provider %q is listed twice", name)
 		}
+		if h.service.AnswersByCallback(name) {
+			return fmt.Errorf("provider %q answers by callback, it cannot be asked together with others", name)
+		}
+		seen[name] = true
+	}
+
+	if req.Strategy == "" {
+		req.Strategy = h.Config.FanOutStrategy
 	}
+	if req.Strategy == "" {
+		req.Strategy = provider.StrategyMajority
+	}
+	if !provider.ValidStrategy(req.Strategy) {
+		return errors.New("strategy must be first-wins, majority, unanimous or all-must-agree")
+	}
+
+	return nil
+}
 
-	// make request
+// newQuery is make a pending request from a validated QueryRequest
+func newQuery(req *QueryRequest, userID string) *models.Query {
 	query := &models.Query{
-		ID:              generateID(),
+		ID:              idgen.New(),
 		CadastralNumber: req.CadastralNumber,
 		Latitude:        req.Latitude,
 		Longitude:       req.Longitude,
-		Status:          "pending",
+		Status:          models.StatusPending,
 		UserID:          userID,
 		CreatedAt:       time.Now(),
+		CallbackURL:     req.CallbackURL,
+		Provider:        req.Provider,
+		Providers:       req.Providers,
+		Strategy:        req.Strategy,
 	}
+	// both are validated already
+	query.Timeout, _ = time.ParseDuration(req.Timeout)
+	if req.RetryOnTimeout != nil {
+		query.RetryOnTimeout = *req.RetryOnTimeout
+	}
+	query.ParseCadastral()
+	return query
+}
 
-	if err := h.repo.CreateQuery(c.Request.Context(), query); err != nil {
+// submitError is answer on a failed submit, saturated queue asks client to retry later
+func (h *Handler) submitError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserQueueFull):
+		h.setRetryAfter(c)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrQueueFull):
+		h.setRetryAfter(c)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create query"})
-		return
 	}
+}
 
-	// run asynchronous processing
-	go h.service.ProcessQuery(query)
-
-	// return answer
-	response := QueryResponse{
-		ID:              query.ID,
-		CadastralNumber: query.CadastralNumber,
-		Latitude:        query.Latitude,
-		Longitude:       query.Longitude,
-		Status:          query.Status,
-		CreatedAt:       query.CreatedAt,
+func (h *Handler) setRetryAfter(c *gin.Context) {
+	seconds := int(math.Ceil(h.service.RetryAfter().Seconds()))
+	if seconds < 1 {
+		seconds = 1
 	}
-
-	c.JSON(http.StatusAccepted, response)
+	c.Header("Retry-After", strconv.Itoa(seconds))
 }
 
-// GetHistory is take history of request
+// GetHistory is take history of request. With ?cursor (empty for the first
+// page) it pages by keyset and answers with an envelope, otherwise by page
+// number with a plain array as before.
 func (h *Handler) GetHistory(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	// taken parametrs of pagination
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "20")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
 
-	// take user ID from context if auth exist
-	var userID string
-	if claims, exists := c.Get("userClaims"); exists {
-		if userClaims, ok := claims.(*Claims); ok {
-			userID = userClaims.UserID
-		}
+	filter, err := historyFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		h.getHistoryPage(c, filter, cursor, limit)
+		return
+	}
+
+	// taken parametrs of pagination
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+		return
 	}
 
-	queries, err := h.repo.GetQueries(ctx, userID, page, limit)
+	queries, err := h.repo.GetQueries(ctx, filter, page, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get queries"})
 		return
@@ -155,21 +329,52 @@ func (h *Handler) GetHistory(c *gin.Context) {
 	// transform in response
 	responses := make([]QueryResponse, len(queries))
 	for i, query := range queries {
-		responses[i] = QueryResponse{
-			ID:              query.ID,
-			CadastralNumber: query.CadastralNumber,
-			Latitude:        query.Latitude,
-			Longitude:       query.Longitude,
-			Status:          query.Status,
-			Result:          query.Result,
-			CreatedAt:       query.CreatedAt,
-			CompletedAt:     query.CompletedAt,
-		}
+		responses[i] = newQueryResponse(&query)
 	}
 
 	c.JSON(http.StatusOK, responses)
 }
 
+// getHistoryPage is one page of history by keyset on (created_at, id)
+func (h *Handler) getHistoryPage(c *gin.Context, filter repository.QueryFilter, cursor string, limit int) {
+	ctx := c.Request.Context()
+
+	if filter.Sort != "created_at" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor pagination supports only sort=created_at"})
+		return
+	}
+
+	key, err := decodeCursor(cursor)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queries, more, err := h.repo.GetQueriesPage(ctx, filter, key, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get queries"})
+		return
+	}
+
+	page := HistoryPage{Items: make([]QueryResponse, len(queries))}
+	for i, query := range queries {
+		page.Items[i] = newQueryResponse(&query)
+	}
+	page.NextCursor, page.PrevCursor = pageCursors(queries, key, more)
+
+	// counting is a full scan of the filter, only on demand
+	if c.Query("include_total") == "true" {
+		total, err := h.repo.CountQueries(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count queries"})
+			return
+		}
+		page.Total = &total
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
 // GetHistoryByCadastral need to take history by cadastral number
 func (h *Handler) GetHistoryByCadastral(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -180,14 +385,14 @@ func (h *Handler) GetHistoryByCadastral(c *gin.Context) {
 		return
 	}
 
-	// take user ID from context if auth exist
-	var userID string
-	if claims, exists := c.Get("userClaims"); exists {
-		if userClaims, ok := claims.(*Claims); ok {
-			userID = userClaims.UserID
-		}
+	// numbers are stored normalized, old malformed ones are looked up as is
+	if number, err := cadastral.Normalize(cadastralNumber); err == nil {
+		cadastralNumber = number
 	}
 
+	// take user ID from context if auth exist
+	userID := currentUserID(c)
+
 	queries, err := h.repo.GetQueriesByCadastral(ctx, cadastralNumber, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get queries"})
@@ -197,21 +402,181 @@ func (h *Handler) GetHistoryByCadastral(c *gin.Context) {
 	// transform in response
 	responses := make([]QueryResponse, len(queries))
 	for i, query := range queries {
-		responses[i] = QueryResponse{
-			ID:              query.ID,
-			CadastralNumber: query.CadastralNumber,
-			Latitude:        query.Latitude,
-			Longitude:       query.Longitude,
-			Status:          query.Status,
-			Result:          query.Result,
-			CreatedAt:       query.CreatedAt,
-			CompletedAt:     query.CompletedAt,
-		}
+		responses[i] = newQueryResponse(&query)
 	}
 
 	c.JSON(http.StatusOK, responses)
 }
 
+// GetQuery is take one request by ID. With ?wait=30s it blocks until the
+// request reaches a terminal status or the wait elapses (long polling).
+func (h *Handler) GetQuery(c *gin.Context) {
+	var wait time.Duration
+	if value := c.Query("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "wait must be a duration like 30s"})
+			return
+		}
+		if wait > maxLongPollWait {
+			wait = maxLongPollWait
+		}
+	}
+
+	if wait == 0 {
+		query, ok := h.findQuery(c)
+		if !ok {
+			return
+		}
+		h.writeQuery(c, query)
+		return
+	}
+
+	// subscribe before reading the request so no transition is missed
+	ch, unsubscribe := h.service.Subscribe(c.Param("id"))
+	defer unsubscribe()
+
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	if !models.IsTerminalStatus(query.Status) && waitTerminal(c.Request.Context(), ch, wait) {
+		if query, ok = h.findQuery(c); !ok {
+			return
+		}
+	}
+
+	h.writeQuery(c, query)
+}
+
+// writeQuery is answer with one request, a fanned out one with the answers
+// of its providers
+func (h *Handler) writeQuery(c *gin.Context, query *models.Query) {
+	response := newQueryResponse(query)
+
+	if len(query.Providers) > 0 {
+		answers, err := h.repo.GetProviderAnswers(c.Request.Context(), query.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get provider answers"})
+			return
+		}
+		response.Answers = answers
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// waitTerminal is block until a terminal event arrives, returns false on
+// timeout and when the server shuts down
+func waitTerminal(ctx context.Context, ch <-chan events.Event, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if models.IsTerminalStatus(event.Status) {
+				return true
+			}
+		}
+	}
+}
+
+// findQuery is load the request from the path and check that it belongs
+// to the current user, writes the error response itself
+func (h *Handler) findQuery(c *gin.Context) (*models.Query, bool) {
+	query, err := h.repo.GetQueryByID(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "query not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get query"})
+		return nil, false
+	}
+
+	// somebody else's request looks the same as a missing one
+	if userID := currentUserID(c); userID != "" && query.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "query not found"})
+		return nil, false
+	}
+
+	return query, true
+}
+
+// GetQueryAttempts is return the raw exchanges with the external server
+// made for a request, oldest first
+func (h *Handler) GetQueryAttempts(c *gin.Context) {
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	attempts, err := h.repo.GetQueryAttempts(c.Request.Context(), query.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get query attempts"})
+		return
+	}
+
+	if attempts == nil {
+		attempts = []models.QueryAttempt{}
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// RequeueQuery is send a dead-lettered request back to the queue
+func (h *Handler) RequeueQuery(c *gin.Context) {
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	requeued, err := h.service.Requeue(c.Request.Context(), query.ID, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to requeue query"})
+		return
+	}
+	if !requeued {
+		c.JSON(http.StatusConflict, gin.H{"error": "query is not dead-lettered, failed or timed out"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": query.ID, "status": models.StatusPending})
+}
+
+// CancelQuery is cancel a pending or processing request, a call to the
+// provider in flight is aborted
+func (h *Handler) CancelQuery(c *gin.Context) {
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	cancelled, err := h.service.Cancel(c.Request.Context(), query.ID, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel query"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "query is already finished"})
+		return
+	}
+
+	if query, ok = h.findQuery(c); !ok {
+		return
+	}
+	h.writeQuery(c, query)
+}
+
 // ProcessResult its external sever emulation
 func (h *Handler) ProcessResult(c *gin.Context) {
 	// imitation of processing until 60 sec
@@ -229,7 +594,7 @@ func (h *Handler) ProcessResult(c *gin.Context) {
 
 // Login its auth user
 func (h *Handler) Login(c *gin.Context) {
-	if !h.config.Auth.Enabled {
+	if !h.Config.Auth.Enabled {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "authentication is disabled"})
 		return
 	}
@@ -259,7 +624,7 @@ func (h *Handler) Login(c *gin.Context) {
 		"exp":      time.Now().Add(24 * time.Hour).Unix(),
 	})
 
-	tokenString, err := token.SignedString([]byte(h.config.Auth.JWTSecret))
+	tokenString, err := token.SignedString([]byte(h.Config.Auth.JWTSecret))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
@@ -270,7 +635,7 @@ func (h *Handler) Login(c *gin.Context) {
 
 // Register its register user
 func (h *Handler) Register(c *gin.Context) {
-	if !h.config.Auth.Enabled {
+	if !h.Config.Auth.Enabled {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "registration is disabled"})
 		return
 	}
@@ -290,13 +655,18 @@ func (h *Handler) Register(c *gin.Context) {
 
 	// create password
 	user := &models.User{
-		ID:           generateID(),
+		ID:           idgen.New(),
 		Username:     req.Username,
 		PasswordHash: string(hashedPassword),
 		CreatedAt:    time.Now(),
 	}
 
-	if err := h.repo.CreateUser(c.Request.Context(), user); err != nil {
+	err = h.repo.CreateUser(c.Request.Context(), user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "username is already taken"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
 		return
 	}
@@ -313,16 +683,40 @@ func (h *Handler) SwaggerHandler(c *gin.Context) {
 	})
 }
 
-// helpful function
-func generateID() string {
-	return time.Now().Format("20060102150405") + randomString(6)
+// newQueryResponse is transform a request in response
+func newQueryResponse(query *models.Query) QueryResponse {
+	resp := QueryResponse{
+		ID:              query.ID,
+		CadastralNumber: query.CadastralNumber,
+		Cadastral:       query.Cadastral,
+		Latitude:        query.Latitude,
+		Longitude:       query.Longitude,
+		Status:          query.Status,
+		Result:          query.Result,
+		CreatedAt:       query.CreatedAt,
+		CompletedAt:     query.CompletedAt,
+		Attempts:        query.Attempts,
+		LastError:       query.LastError,
+		NextAttemptAt:   query.NextAttemptAt,
+		Cached:          query.Cached,
+		Provider:        query.Provider,
+		Providers:       query.Providers,
+		Strategy:        query.Strategy,
+		Disagreement:    query.Disagreement,
+		RetryOnTimeout:  query.RetryOnTimeout,
+	}
+	if query.Timeout > 0 {
+		resp.Timeout = query.Timeout.String()
+	}
+	return resp
 }
 
-func randomString(n int) string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// currentUserID is return ID of the authenticated user, empty without auth
+func currentUserID(c *gin.Context) string {
+	if claims, exists := c.Get("userClaims"); exists {
+		if userClaims, ok := claims.(*Claims); ok {
+			return userClaims.UserID
+		}
 	}
-	return string(b)
+	return ""
 }
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+	"cadastral-service/pkg/idgen"
+)
+
+// maxImportRows limits data rows of one uploaded file
+const maxImportRows = 10000
+
+type ImportResponse struct {
+	BatchID   string `json:"batch_id"`
+	Accepted  int    `json:"accepted"`
+	Rejected  int    `json:"rejected"`
+	ReportURL string `json:"report_url"`
+	// CallbackSecret signs deliveries to every callback_url of the file
+	CallbackSecret string `json:"callback_secret,omitempty"`
+}
+
+// rowReader is a stream of table rows, io.EOF after the last one
+type rowReader interface {
+	Next() ([]string, error)
+}
+
+// ImportQueries is create requests from an uploaded CSV or XLSX file with
+// cadastral_number, latitude and longitude columns. Rows are validated like
+// POST /query, the report of accepted and rejected rows can be downloaded.
+func (h *Handler) ImportQueries(c *gin.Context) {
+	h.limitBody(c)
+
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart/form-data upload with a file field is required"})
+		return
+	}
+
+	// find the file part without buffering the whole form
+	var part io.Reader
+	var filename, contentType string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file field is required"})
+			return
+		}
+		if err != nil {
+			if !h.bodyTooLarge(c, err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart body"})
+			}
+			return
+		}
+		if p.FormName() == "file" {
+			part, filename, contentType = p, p.FileName(), p.Header.Get("Content-Type")
+			break
+		}
+	}
+
+	var rows rowReader
+	switch importFormat(c.Query("format"), filename, contentType) {
+	case "csv":
+		rows = newCSVRows(part)
+	case "xlsx":
+		xlsxRows, cleanup, err := newXLSXRows(part)
+		if err != nil {
+			if !h.bodyTooLarge(c, err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read xlsx file: " + err.Error()})
+			}
+			return
+		}
+		defer cleanup()
+		rows = xlsxRows
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file format, use csv or xlsx"})
+		return
+	}
+
+	header, err := rows.Next()
+	if err != nil {
+		if !h.bodyTooLarge(c, err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file has no header row"})
+		}
+		return
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := currentUserID(c)
+	batch := &models.Batch{
+		ID:        idgen.New(),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+
+	var queries []*models.Query
+	var report []models.ImportRow
+	response := ImportResponse{BatchID: batch.ID}
+
+	// the header is row 1, as spreadsheets count
+	for rowNumber := 2; ; rowNumber++ {
+		record, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !h.bodyTooLarge(c, err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("row %d: %v", rowNumber, err)})
+			}
+			return
+		}
+		if isEmptyRecord(record) {
+			continue
+		}
+		if len(report) >= maxImportRows {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file is limited to %d rows", maxImportRows)})
+			return
+		}
+
+		row := models.ImportRow{
+			BatchID:         batch.ID,
+			Row:             rowNumber,
+			CadastralNumber: columns.value(record, "cadastral_number"),
+			Latitude:        columns.value(record, "latitude"),
+			Longitude:       columns.value(record, "longitude"),
+		}
+
+		req, err := importRequest(&row, columns.value(record, "callback_url"))
+		if err == nil {
+			err = h.validateQueryRequest(req)
+		}
+		if err != nil {
+			row.Error = err.Error()
+			response.Rejected++
+		} else {
+			query := newQuery(req, userID)
+			query.BatchID = batch.ID
+			queries = append(queries, query)
+			row.QueryID = query.ID
+			response.Accepted++
+		}
+		report = append(report, row)
+	}
+
+	if len(report) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file has no data rows"})
+		return
+	}
+
+	batch.Total = len(queries)
+	response.CallbackSecret = signCallbacks(queries...)
+	if err := h.service.SubmitImport(c.Request.Context(), batch, queries, report); err != nil {
+		h.submitError(c, err)
+		return
+	}
+
+	response.ReportURL = "/api/v1/queries/import/" + batch.ID + "/report"
+	c.JSON(http.StatusAccepted, response)
+}
+
+// limitBody is cap the request body at the configured upload size
+func (h *Handler) limitBody(c *gin.Context) {
+	if h.Config.MaxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.Config.MaxUploadSize)
+	}
+}
+
+// bodyTooLarge is answer 413 when err is the body going over the limit of limitBody
+func (h *Handler) bodyTooLarge(c *gin.Context, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("body is limited to %d bytes", maxErr.Limit)})
+	return true
+}
+
+// GetImportReport is download the report of an import as CSV
+func (h *Handler) GetImportReport(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	batch, err := h.repo.GetBatch(ctx, c.Param("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get import"})
+		return
+	}
+
+	if userID := currentUserID(c); userID != "" && batch.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import not found"})
+		return
+	}
+
+	report, err := h.repo.GetImportRows(ctx, batch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get import report"})
+		return
+	}
+	if len(report) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "batch was not created by an import"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-report.csv"`, batch.ID))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"row", "cadastral_number", "latitude", "longitude", "status", "query_id", "error"})
+	for _, row := range report {
+		status := "accepted"
+		if row.Error != "" {
+			status = "rejected"
+		}
+		w.Write([]string{
+			strconv.Itoa(row.Row),
+			csvSafe(row.CadastralNumber),
+			csvSafe(row.Latitude),
+			csvSafe(row.Longitude),
+			status,
+			row.QueryID,
+			csvSafe(row.Error),
+		})
+	}
+	w.Flush()
+}
+
+// csvSafe is keep a cell of the uploaded file from running as a formula
+// when the report is opened in a spreadsheet: one starting with =, +, -, @,
+// tab or carriage return gets a leading quote, numbers like -33.5 are kept
+func csvSafe(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// importFormat is pick the parser by ?format, file extension or content type
+func importFormat(format, filename, contentType string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		return "csv"
+	case ".xlsx":
+		return "xlsx"
+	}
+
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return "csv"
+	case strings.HasPrefix(contentType, "application/vnd.openxmlformats-officedocument.spreadsheetml"):
+		return "xlsx"
+	}
+	return ""
+}
+
+// columnIndex is position of every known column in the header
+type columnIndex map[string]int
+
+// columnAliases maps accepted header names to columns
+var columnAliases = map[string]string{
+	"cadastral_number": "cadastral_number",
+	"cadastral":        "cadastral_number",
+	"latitude":         "latitude",
+	"lat":              "latitude",
+	"longitude":        "longitude",
+	"lon":              "longitude",
+	"lng":              "longitude",
+	"callback_url":     "callback_url",
+}
+
+func importColumns(header []string) (columnIndex, error) {
+	columns := make(columnIndex)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if column, ok := columnAliases[name]; ok {
+			if _, seen := columns[column]; !seen {
+				columns[column] = i
+			}
+		}
+	}
+
+	for _, required := range []string{"cadastral_number", "latitude", "longitude"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("header must contain %s column", required)
+		}
+	}
+	return columns, nil
+}
+
+func (ci columnIndex) value(record []string, column string) string {
+	i, ok := ci[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// importRequest is parse the raw values of a row, decimal comma is accepted
+func importRequest(row *models.ImportRow, callbackURL string) (*QueryRequest, error) {
+	if row.Latitude == "" {
+		return nil, errors.New("latitude is required")
+	}
+	if row.Longitude == "" {
+		return nil, errors.New("longitude is required")
+	}
+
+	latitude, err := strconv.ParseFloat(strings.Replace(row.Latitude, ",", ".", 1), 64)
+	if err != nil {
+		return nil, errors.New("latitude is not a number")
+	}
+	longitude, err := strconv.ParseFloat(strings.Replace(row.Longitude, ",", ".", 1), 64)
+	if err != nil {
+		return nil, errors.New("longitude is not a number")
+	}
+
+	return &QueryRequest{
+		CadastralNumber: row.CadastralNumber,
+		Latitude:        latitude,
+		Longitude:       longitude,
+		CallbackURL:     callbackURL,
+	}, nil
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type csvRows struct {
+	r *csv.Reader
+}
+
+// newCSVRows is read CSV as it is uploaded. The delimiter is guessed from
+// the first line because spreadsheets in ru locale export with ';'.
+func newCSVRows(r io.Reader) *csvRows {
+	br := bufio.NewReader(r)
+	firstLine, _ := br.Peek(4096)
+	if i := bytes.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	return &csvRows{r: reader}
+}
+
+func (cr *csvRows) Next() ([]string, error) {
+	return cr.r.Read()
+}
+
+type xlsxRows struct {
+	rows *excelize.Rows
+}
+
+// newXLSXRows is iterate rows of the first sheet. XLSX is a zip archive and
+// needs random access, so the upload is spooled to a temporary file first;
+// rows are still read one by one.
+func newXLSXRows(r io.Reader) (*xlsxRows, func(), error) {
+	tmp, err := os.CreateTemp("", "import-*.xlsx")
+	if err != nil {
+		return nil, nil, err
+	}
+	removeTmp := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		removeTmp()
+		return nil, nil, err
+	}
+
+	f, err := excelize.OpenFile(tmp.Name())
+	if err != nil {
+		removeTmp()
+		return nil, nil, err
+	}
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		f.Close()
+		removeTmp()
+		return nil, nil, errors.New("workbook has no sheets")
+	}
+
+	rows, err := f.Rows(sheets[0])
+	if err != nil {
+		f.Close()
+		removeTmp()
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		rows.Close()
+		f.Close()
+		removeTmp()
+	}
+	return &xlsxRows{rows: rows}, cleanup, nil
+}
+
+func (xr *xlsxRows) Next() ([]string, error) {
+	if !xr.rows.Next() {
+		if err := xr.rows.Error(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return xr.rows.Columns()
+}
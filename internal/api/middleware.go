@@ -15,7 +15,7 @@ type Claims struct {
 
 func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !h.config.Auth.Enabled {
+		if !h.Config.Auth.Enabled {
 			c.Next()
 			return
 		}
@@ -35,7 +35,7 @@ func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 		}
 
 		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(h.config.Auth.JWTSecret), nil
+			return []byte(h.Config.Auth.JWTSecret), nil
 		})
 
 		if err != nil {
@@ -4,35 +4,54 @@ import (
 	"cadastral-service/internal/config"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-delve/delve/pkg/config"
 )
 
-func SetupRoutes(router *gin.Engine, handler *Handler, cfg *config.Config){
+func SetupRoutes(router *gin.Engine, handler *Handler, cfg *config.Config) {
 	//API group v1
 	v1 := router.Group("/api/v1")
 
 	//public endpoints
 	v1.GET("/ping", handler.Ping)
+	v1.GET("/status", handler.Status)
 
 	// protected endpoints with authorization if it turn on
+	protected := v1.Group("/")
 	if cfg.Auth.Enabled {
 		v1.POST("/login", handler.Login)
 		v1.POST("/register", handler.Register)
-		
+
 		//use middleware auth
-		authGroup := v1.Group("/")
-		authGroup.Use(handler.AuthMiddleware())
-		{
-			authGroup.POST("/query", handler.CreateQuery)
-			authGroup.GET("/history", handler.GetHistory)
-			authGroup.GET("/history/:cadastral_number", handler.GetHistoryByCadastral)
-		}
-	} else {
-		//without auth
-		v1.POST("/query", handler.CreateQuery)
-		v1.GET("/history", handler.GetHistory)
-		v1.GET("/history/:cadastral_number", handler.GetHistoryByCadastral)
+		protected.Use(handler.AuthMiddleware())
+
+		//per user settings exist only with auth
+		protected.GET("/webhook", handler.GetWebhook)
+		protected.PUT("/webhook", handler.PutWebhook)
 	}
+
+	protected.POST("/query", handler.CreateQuery)
+	protected.GET("/query/:id", handler.GetQuery)
+	protected.DELETE("/query/:id", handler.CancelQuery)
+	protected.GET("/query/:id/attempts", handler.GetQueryAttempts)
+	protected.GET("/query/:id/events", handler.QueryEvents)
+	protected.GET("/query/:id/ws", handler.QueryEventsWS)
+	protected.POST("/query/:id/requeue", handler.RequeueQuery)
+	protected.POST("/queries/batch", handler.CreateBatch)
+	protected.GET("/queries/batch/:id", handler.GetBatch)
+	protected.POST("/queries/import", handler.ImportQueries)
+	protected.GET("/queries/import/:id/report", handler.GetImportReport)
+	protected.GET("/history", handler.GetHistory)
+	protected.GET("/history/export", handler.ExportHistory)
+	protected.GET("/history/:cadastral_number", handler.GetHistoryByCadastral)
+	protected.GET("/webhooks/deliveries", handler.GetWebhookDeliveries)
+	protected.GET("/webhooks/deliveries/:id", handler.GetWebhookDelivery)
+	protected.POST("/webhooks/deliveries/:id/redeliver", handler.RedeliverWebhook)
+
+	//verdicts of providers answering by callback, signed instead of authorized
+	v1.POST("/callbacks/:provider", handler.ProviderCallback)
+
+	//metrics for scraping
+	router.GET("/metrics", handler.Metrics)
+
 	//endpoint for external server emulation
 	router.POST("/api/result", handler.ProcessResult)
 
@@ -40,4 +59,4 @@ func SetupRoutes(router *gin.Engine, handler *Handler, cfg *config.Config){
 	if cfg.DocsEnabled {
 		router.GET("/swagger/*any", handler.SwaggerHandler)
 	}
-}
\ No newline at end of file
+}
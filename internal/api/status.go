@@ -0,0 +1,41 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"cadastral-service/internal/metrics"
+	"cadastral-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusResponse is the health of the service and its providers
+type StatusResponse struct {
+	// Status is degraded while a breaker of some provider is not closed
+	Status    string                  `json:"status"`
+	Providers []service.BreakerStatus `json:"providers"`
+}
+
+// Status is report the circuit breakers of providers
+func (h *Handler) Status(c *gin.Context) {
+	response := StatusResponse{Status: "ok", Providers: h.service.Breakers()}
+	for _, b := range response.Providers {
+		if b.State != service.BreakerClosed {
+			response.Status = "degraded"
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Metrics is serve metrics in the Prometheus text format
+func (h *Handler) Metrics(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf, h.service.Metrics()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write metrics"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
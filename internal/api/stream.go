@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+)
+
+const (
+	// keepAliveInterval is how often an idle stream is pinged so proxies keep it open
+	keepAliveInterval = 15 * time.Second
+	wsWriteTimeout    = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// QueryEvents is stream status transitions of a request as Server-Sent Events
+func (h *Handler) QueryEvents(c *gin.Context) {
+	// subscribe before reading the request so no transition is missed
+	ch, unsubscribe := h.service.Subscribe(c.Param("id"))
+	defer unsubscribe()
+
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("status", currentEvent(query))
+	c.Writer.Flush()
+	if models.IsTerminalStatus(query.Status) {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-ch:
+			// closed when the server shuts down
+			if !ok {
+				return
+			}
+			c.SSEvent("status", event)
+			c.Writer.Flush()
+			if models.IsTerminalStatus(event.Status) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// QueryEventsWS is stream status transitions of a request over WebSocket
+func (h *Handler) QueryEventsWS(c *gin.Context) {
+	ch, unsubscribe := h.service.Subscribe(c.Param("id"))
+	defer unsubscribe()
+
+	query, ok := h.findQuery(c)
+	if !ok {
+		return
+	}
+
+	u := upgrader
+	u.CheckOrigin = h.allowedOrigin
+	conn, err := u.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// upgrader has already answered the client
+		return
+	}
+	defer conn.Close()
+
+	// read until the client goes away, this also handles control frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event events.Event) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(event) == nil
+	}
+
+	if !send(currentEvent(query)) || models.IsTerminalStatus(query.Status) {
+		closeWS(conn, websocket.CloseNormalClosure, "query finished")
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-ch:
+			if !ok {
+				// the server is shutting down
+				closeWS(conn, websocket.CloseGoingAway, "server shutting down")
+				return
+			}
+			if !send(event) {
+				return
+			}
+			if models.IsTerminalStatus(event.Status) {
+				closeWS(conn, websocket.CloseNormalClosure, "query finished")
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// allowedOrigin is tell whether a page may open an event WebSocket, browsers
+// do not apply CORS to WebSockets. Clients other than browsers send no Origin.
+func (h *Handler) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.Config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// closeWS is tell the client the stream is over
+func closeWS(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+}
+
+// currentEvent is describe the stored state of a request as an event
+func currentEvent(query *models.Query) events.Event {
+	return events.Event{
+		QueryID:  query.ID,
+		Status:   query.Status,
+		Result:   query.Result,
+		Attempts: query.Attempts,
+		Error:    query.LastError,
+		Time:     time.Now(),
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+	"cadastral-service/internal/service"
+	"cadastral-service/pkg/idgen"
+)
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+	// Secret signs deliveries, one is generated and returned once when it is empty
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryResponse struct {
+	models.WebhookDelivery
+	Log []models.WebhookAttempt `json:"log"`
+}
+
+// GetWebhook is return webhook settings of the current user
+func (h *Handler) GetWebhook(c *gin.Context) {
+	user, err := h.repo.GetUserByID(c.Request.Context(), currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":        user.WebhookURL,
+		"has_secret": user.WebhookSecret != "",
+	})
+}
+
+// PutWebhook is register a callback URL for every request of the current user,
+// an empty url removes it
+func (h *Handler) PutWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.URL != "" && !h.validCallbackURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an absolute http(s) URL of a public host"})
+		return
+	}
+
+	response := gin.H{"url": req.URL, "has_secret": req.URL != ""}
+	if req.URL == "" {
+		req.Secret = ""
+	} else if req.Secret == "" {
+		req.Secret = idgen.Secret()
+		response["secret"] = req.Secret
+	}
+
+	if err := h.repo.UpdateUserWebhook(c.Request.Context(), currentUserID(c), req.URL, req.Secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetWebhookDeliveries is list latest webhook deliveries, ?status=failed
+// shows the ones which ran out of attempts
+func (h *Handler) GetWebhookDeliveries(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	deliveries, err := h.repo.GetWebhookDeliveries(c.Request.Context(), currentUserID(c), status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhook deliveries"})
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// GetWebhookDelivery is return one delivery with its log of attempts
+func (h *Handler) GetWebhookDelivery(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	delivery, err := h.repo.GetWebhookDelivery(ctx, c.Param("id"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook delivery not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhook delivery"})
+		return
+	}
+
+	if userID := currentUserID(c); userID != "" && delivery.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook delivery not found"})
+		return
+	}
+
+	attempts, err := h.repo.GetWebhookAttempts(ctx, delivery.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhook attempts"})
+		return
+	}
+
+	if attempts == nil {
+		attempts = []models.WebhookAttempt{}
+	}
+	c.JSON(http.StatusOK, WebhookDeliveryResponse{WebhookDelivery: *delivery, Log: attempts})
+}
+
+// RedeliverWebhook is send a failed delivery again
+func (h *Handler) RedeliverWebhook(c *gin.Context) {
+	id := c.Param("id")
+
+	ok, err := h.service.Redeliver(c.Request.Context(), id, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to redeliver webhook"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no failed webhook delivery with this id"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": models.DeliveryPending})
+}
+
+// signCallbacks is give the requests with a callback_url one generated
+// secret to sign their deliveries, empty when none has a callback_url.
+// Requests submitted together share it, they have the same submitter.
+func signCallbacks(queries ...*models.Query) string {
+	var secret string
+	for _, query := range queries {
+		if query.CallbackURL == "" {
+			continue
+		}
+		if secret == "" {
+			secret = idgen.Secret()
+		}
+		query.CallbackSecret = secret
+	}
+	return secret
+}
+
+// validCallbackURL is accept only absolute http and https URLs, of public
+// hosts unless private ones are allowed
+func (h *Handler) validCallbackURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	return h.Config.Webhook.AllowPrivateHosts || !service.PrivateHost(u.Hostname())
+}
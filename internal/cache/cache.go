@@ -0,0 +1,40 @@
+// Package cache keeps results of the external server, so a parcel checked
+// again within the TTL does not wait on it a second time
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cadastral-service/internal/config"
+)
+
+// Cache is a store of check results by key, entries expire after their TTL
+type Cache interface {
+	// Get is return a result, ok is false on a miss or an expired entry
+	Get(ctx context.Context, key string) (result bool, ok bool, err error)
+	Set(ctx context.Context, key string, result bool, ttl time.Duration) error
+}
+
+// Key is the cache key of a check: the provider, the normalized number and
+// coordinates rounded to about ten centimetres
+func Key(provider, cadastralNumber string, latitude, longitude float64) string {
+	return fmt.Sprintf("%s|%s|%.6f|%.6f", provider, cadastralNumber, latitude, longitude)
+}
+
+// New is the cache of the config: Redis when RedisURL is set, in-process
+// LRU otherwise. Returns nil when caching is off.
+func New(cfg config.CacheConfig) (Cache, error) {
+	if cfg.TTL <= 0 {
+		return nil, nil
+	}
+	if cfg.RedisURL != "" {
+		c, err := NewRedis(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return NewLRU(cfg.Size), nil
+}
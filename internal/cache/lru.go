@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process cache of at most size entries, the least recently
+// used entry is evicted first
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key       string
+	result    bool
+	expiresAt time.Time
+}
+
+var _ Cache = (*LRU)(nil)
+
+func NewLRU(size int) *LRU {
+	if size < 1 {
+		size = 1
+	}
+	return &LRU{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get is return a result and mark it recently used
+func (c *LRU) Get(ctx context.Context, key string) (bool, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false, false, nil
+	}
+
+	entry := el.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(el)
+		return false, false, nil
+	}
+
+	c.order.MoveToFront(el)
+	return entry.result, true, nil
+}
+
+// Set is store a result, evicting the oldest entry when the cache is full
+func (c *LRU) Set(ctx context.Context, key string, result bool, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.result = result
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, result: result, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Len is number of entries, expired ones included until they are evicted
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix keeps results apart from other data in a shared Redis
+const redisKeyPrefix = "cadastral:result:"
+
+// Redis is a cache shared by every instance, in Redis or anything speaking
+// its protocol
+type Redis struct {
+	client *redis.Client
+}
+
+var _ Cache = (*Redis)(nil)
+
+// NewRedis is connect to redis://[:password@]host:port/db
+func NewRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache redis url: %w", err)
+	}
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+// Get is return a result, a missing key is a miss
+func (c *Redis) Get(ctx context.Context, key string) (bool, bool, error) {
+	value, err := c.client.Get(ctx, redisKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return value == "1", true, nil
+}
+
+// Set is store a result, Redis expires it itself
+func (c *Redis) Set(ctx context.Context, key string, result bool, ttl time.Duration) error {
+	value := "0"
+	if result {
+		value = "1"
+	}
+	return c.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err()
+}
+
+func (c *Redis) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,183 @@
+package cadastral
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// segment names in the order they appear in a number
+var segments = [4]string{"district", "area", "block", "parcel"}
+
+// width of the zero padded segments, the parcel is not padded
+const (
+	districtWidth = 2
+	areaWidth     = 2
+	blockWidth    = 7
+	maxParcelLen  = 12
+)
+
+// Number is a Russian cadastral number district:area:block:parcel,
+// e.g. 77:01:0001001:1234 (округ:район:квартал:участок)
+type Number struct {
+	District string `json:"district"`
+	Area     string `json:"area"`
+	Block    string `json:"block"`
+	Parcel   string `json:"parcel"`
+}
+
+// String is the normalized form of the number
+func (n Number) String() string {
+	return n.District + ":" + n.Area + ":" + n.Block + ":" + n.Parcel
+}
+
+// Error is a malformed cadastral number, Segment names the bad part
+type Error struct {
+	Input string
+	// Segment is district, area, block or parcel; empty when the number
+	// as a whole is wrong
+	Segment string
+	// Position is 1-based index of the segment
+	Position int
+	Value    string
+	Reason   string
+}
+
+func (e *Error) Error() string {
+	if e.Segment == "" {
+		return fmt.Sprintf("cadastral number %q %s", e.Input, e.Reason)
+	}
+	return fmt.Sprintf("cadastral number %q: %s (segment %d) %s, got %q",
+		e.Input, e.Segment, e.Position, e.Reason, e.Value)
+}
+
+// Parse is validate a cadastral number and split it into components.
+// Whitespace is ignored, district and area are padded to 2 digits, block
+// to 7 digits and leading zeros of the parcel are dropped.
+func Parse(input string) (Number, error) {
+	compact := removeSpaces(input)
+	if compact == "" {
+		return Number{}, &Error{Input: input, Reason: "is empty"}
+	}
+
+	parts := strings.Split(compact, ":")
+	if len(parts) != len(segments) {
+		return Number{}, &Error{
+			Input:  input,
+			Reason: fmt.Sprintf("must have 4 segments district:area:block:parcel separated by ':', got %d", len(parts)),
+		}
+	}
+
+	for i, part := range parts {
+		if err := checkDigits(input, i, part); err != nil {
+			return Number{}, err
+		}
+	}
+
+	district, err := pad(input, 0, parts[0], districtWidth)
+	if err != nil {
+		return Number{}, err
+	}
+	area, err := pad(input, 1, parts[1], areaWidth)
+	if err != nil {
+		return Number{}, err
+	}
+	block, err := pad(input, 2, parts[2], blockWidth)
+	if err != nil {
+		return Number{}, err
+	}
+
+	parcel := strings.TrimLeft(parts[3], "0")
+	if parcel == "" {
+		return Number{}, segmentError(input, 3, parts[3], "must not be zero")
+	}
+	if len(parcel) > maxParcelLen {
+		return Number{}, segmentError(input, 3, parts[3], fmt.Sprintf("must have at most %d digits", maxParcelLen))
+	}
+
+	return Number{District: district, Area: area, Block: block, Parcel: parcel}, nil
+}
+
+// Normalize is Parse returning the normalized string
+func Normalize(input string) (string, error) {
+	n, err := Parse(input)
+	if err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// NormalizePrefix is normalize the beginning of a number the way Parse does,
+// so it matches normalized numbers: every segment followed by ':' is
+// padded, the last one may be incomplete and is kept as it is
+func NormalizePrefix(input string) (string, error) {
+	parts := strings.Split(removeSpaces(input), ":")
+	if len(parts) > len(segments) {
+		return "", &Error{
+			Input:  input,
+			Reason: fmt.Sprintf("must have at most 4 segments district:area:block:parcel separated by ':', got %d", len(parts)),
+		}
+	}
+
+	widths := [...]int{districtWidth, areaWidth, blockWidth}
+	last := len(parts) - 1
+	for i, part := range parts[:last] {
+		if err := checkDigits(input, i, part); err != nil {
+			return "", err
+		}
+		padded, err := pad(input, i, part, widths[i])
+		if err != nil {
+			return "", err
+		}
+		parts[i] = padded
+	}
+	if parts[last] != "" {
+		if err := checkDigits(input, last, parts[last]); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(parts, ":"), nil
+}
+
+// removeSpaces is drop every whitespace from input
+func removeSpaces(input string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, input)
+}
+
+func checkDigits(input string, i int, part string) error {
+	if part == "" {
+		return segmentError(input, i, part, "is empty")
+	}
+	for _, r := range part {
+		if r < '0' || r > '9' {
+			return segmentError(input, i, part, "must contain only digits")
+		}
+	}
+	return nil
+}
+
+// pad is left pad a segment with zeros to width, longer values are an error
+// unless the extra digits are leading zeros
+func pad(input string, i int, part string, width int) (string, error) {
+	value := strings.TrimLeft(part, "0")
+	if len(value) > width {
+		return "", segmentError(input, i, part, fmt.Sprintf("must have at most %d digits", width))
+	}
+	return strings.Repeat("0", width-len(value)) + value, nil
+}
+
+func segmentError(input string, i int, value, reason string) *Error {
+	return &Error{
+		Input:    input,
+		Segment:  segments[i],
+		Position: i + 1,
+		Value:    value,
+		Reason:   reason,
+	}
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a slow subscriber may lag behind
+const subscriberBuffer = 16
+
+// Event is a status transition of a query
+type Event struct {
+	QueryID  string    `json:"query_id"`
+	Status   string    `json:"status"`
+	Result   *bool     `json:"result,omitempty"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Broker delivers query events to subscribers. Hub keeps everything in
+// process; a broker over Postgres LISTEN/NOTIFY can implement the same
+// interface to fan events out between several instances.
+type Broker interface {
+	Publish(event Event)
+	// Subscribe is return events of one query and a function to unsubscribe
+	Subscribe(queryID string) (<-chan Event, func())
+	// Close is end every subscription when the server shuts down, later
+	// subscriptions are closed at once
+	Close()
+}
+
+// Hub is in-process Broker
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[string]map[chan Event]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan Event]struct{})}
+}
+
+// Publish is send event to every subscriber of its query, a subscriber
+// whose buffer is full misses the event instead of blocking the publisher
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subs[event.QueryID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (h *Hub) Subscribe(queryID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subs[queryID] == nil {
+		h.subs[queryID] = make(map[chan Event]struct{})
+	}
+	h.subs[queryID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	// the channel is closed by whichever of unsubscribe and Close comes first
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[queryID][ch]; !ok {
+			return
+		}
+		delete(h.subs[queryID], ch)
+		if len(h.subs[queryID]) == 0 {
+			delete(h.subs, queryID)
+		}
+		close(ch)
+	}
+
+	return ch, unsubscribe
+}
+
+// Close is close the channel of every subscriber, streams and long polls
+// reading them return
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for queryID, chans := range h.subs {
+		for ch := range chans {
+			close(ch)
+		}
+		delete(h.subs, queryID)
+	}
+}
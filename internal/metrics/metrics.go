@@ -0,0 +1,65 @@
+// Package metrics writes metrics in the Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metric types
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Metric is one family of samples sharing a name
+type Metric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample is one value of a metric, told apart by its labels
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Write is write metrics in the text format served on /metrics
+func Write(w io.Writer, metrics []Metric) error {
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type); err != nil {
+			return err
+		}
+		for _, s := range m.Samples {
+			value := strconv.FormatFloat(s.Value, 'g', -1, 64)
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", m.Name, labels(s.Labels), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// labels is format labels sorted by name, {} is left out when there are none
+func labels(l map[string]string) string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(l[name])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
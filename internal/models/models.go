@@ -1,24 +1,194 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+
+	"cadastral-service/internal/cadastral"
+)
+
+// query statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	// StatusDeadLetter is a request that ran out of retry attempts
+	StatusDeadLetter = "dead_letter"
+	// StatusCancelled is a request its owner cancelled before it finished
+	StatusCancelled = "cancelled"
+	// StatusTimedOut is a request that did not finish within its deadline
+	StatusTimedOut = "timed_out"
 )
 
+// IsTerminalStatus is tell whether a request with this status will not change anymore
+func IsTerminalStatus(status string) bool {
+	switch status {
+	case StatusCompleted, StatusFailed, StatusDeadLetter, StatusCancelled, StatusTimedOut:
+		return true
+	}
+	return false
+}
+
 type Query struct {
-	ID              string    `json:"id"`
-	CadastralNumber string    `json:"cadastral_number"`
-	Latitude        float64   `json:"latitude"`
-	Longitude       float64   `json:"longitude"`
-	Status          string    `json:"status"`
-	Result          *bool     `json:"result,omitempty"`
-	UserID          string    `json:"user_id,omitempty"`
-	CreatedAt       time.Time `json:"created_at"`
-	CompletedAt     time.Time `json:"completed_at,omitempty"`
+	ID              string `json:"id"`
+	CadastralNumber string `json:"cadastral_number"`
+	// Cadastral is the parsed number, nil for numbers stored before validation
+	Cadastral     *cadastral.Number `json:"cadastral,omitempty"`
+	Latitude      float64           `json:"latitude"`
+	Longitude     float64           `json:"longitude"`
+	Status        string            `json:"status"`
+	Result        *bool             `json:"result,omitempty"`
+	UserID        string            `json:"user_id,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"`
+	Attempts      int               `json:"attempts"`
+	LastError     string            `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	CallbackURL   string            `json:"callback_url,omitempty"`
+	// CallbackSecret signs deliveries to CallbackURL, it is shown to the
+	// client once when the request is created
+	CallbackSecret string `json:"-"`
+	BatchID        string `json:"batch_id,omitempty"`
+	// Cached is a result taken from the cache or shared with an identical
+	// request processed at the same time, not fetched for this one
+	Cached bool `json:"cached"`
+	// Provider is name of the service that checks the request
+	Provider string `json:"provider,omitempty"`
+	// Providers check the request together instead of one Provider, their
+	// answers are combined by Strategy
+	Providers []string `json:"providers,omitempty"`
+	Strategy  string   `json:"strategy,omitempty"`
+	// Disagreement is set when the providers gave different verdicts
+	Disagreement bool `json:"disagreement,omitempty"`
+	// ExternalJobID is the job of a provider answering by callback, set
+	// while the request waits for the callback
+	ExternalJobID string `json:"external_job_id,omitempty"`
+	// DeadlineAt is when the request waiting for a callback times out
+	DeadlineAt *time.Time `json:"-"`
+	// LockedUntil is when the lease of the worker processing the request runs out
+	LockedUntil *time.Time `json:"-"`
+	// Timeout is the processing deadline the request asked for, 0 is the default one
+	Timeout time.Duration `json:"-"`
+	// RetryOnTimeout tells whether the request is retried after its deadline passed
+	RetryOnTimeout bool `json:"retry_on_timeout"`
+}
+
+// ParseCadastral is fill Cadastral from CadastralNumber, it stays nil
+// when the stored number is malformed
+func (q *Query) ParseCadastral() {
+	if n, err := cadastral.Parse(q.CadastralNumber); err == nil {
+		q.Cadastral = &n
+	}
+}
+
+// ImportRow is one line of an uploaded file and what happened to it,
+// values are kept as they were in the file
+type ImportRow struct {
+	BatchID         string `json:"batch_id"`
+	Row             int    `json:"row"`
+	CadastralNumber string `json:"cadastral_number"`
+	Latitude        string `json:"latitude"`
+	Longitude       string `json:"longitude"`
+	QueryID         string `json:"query_id,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Batch groups requests submitted together
+type Batch struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Total     int       `json:"total"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type User struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	PasswordHash  string    `json:"-"`
+	WebhookURL    string    `json:"webhook_url,omitempty"`
+	WebhookSecret string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an outbox record of a callback about a finished request
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	QueryID        string          `json:"query_id"`
+	UserID         string          `json:"user_id,omitempty"`
+	URL            string          `json:"url"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	// Secret signs the delivery, it is taken from the owner at claim time
+	Secret string `json:"-"`
+}
+
+// WebhookAttempt is one entry of the delivery log
+type WebhookAttempt struct {
+	DeliveryID string    `json:"delivery_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// QueryAttempt is one call of the external server kept in query_history:
+// what was sent and the raw answer
+type QueryAttempt struct {
+	ID              string          `json:"id"`
+	QueryID         string          `json:"query_id"`
+	Provider        string          `json:"provider,omitempty"`
+	CadastralNumber string          `json:"cadastral_number"`
+	Attempt         int             `json:"attempt"`
+	Status          string          `json:"status"`
+	Request         json.RawMessage `json:"request"`
+	// Response is the body as it came, a non-JSON body is stored as a string
+	Response   json.RawMessage `json:"response,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	LatencyMs  int64           `json:"latency_ms"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// query attempt statuses
+const (
+	AttemptSucceeded = "succeeded"
+	AttemptFailed    = "failed"
+)
+
+// ProviderAnswer is the answer of one provider to a request checked by
+// several of them, Result is nil when the provider failed
+type ProviderAnswer struct {
+	QueryID   string    `json:"-"`
+	Provider  string    `json:"provider"`
+	Result    *bool     `json:"result,omitempty"`
+	Cached    bool      `json:"cached"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JobCallback is the verdict a provider delivered about a job, kept when
+// it came before the job was stored
+type JobCallback struct {
+	Provider   string    `json:"provider"`
+	JobID      string    `json:"job_id"`
+	Result     bool      `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	Body       []byte    `json:"-"`
+	ReceivedAt time.Time `json:"received_at"`
 }
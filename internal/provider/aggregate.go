@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// strategies combining answers of several providers
+const (
+	// StrategyFirstWins takes the first answer, the other calls are cancelled
+	StrategyFirstWins = "first-wins"
+	// StrategyMajority takes the verdict of more than half of the providers asked
+	StrategyMajority = "majority"
+	// StrategyUnanimous is true only when every provider asked says true.
+	// One false answer decides, a failed provider leaves true undecided.
+	StrategyUnanimous = "unanimous"
+	// StrategyAllMustAgree needs an answer of every provider and the same verdict
+	StrategyAllMustAgree = "all-must-agree"
+)
+
+// ErrNoConsensus is returned by Aggregate when the answers do not decide
+var ErrNoConsensus = errors.New("providers did not reach consensus")
+
+// Answer is the outcome of one provider, Err is set when it failed
+type Answer struct {
+	Provider string
+	Result   bool
+	Err      error
+}
+
+// ValidStrategy is tell whether a strategy name is known
+func ValidStrategy(strategy string) bool {
+	switch strategy {
+	case StrategyFirstWins, StrategyMajority, StrategyUnanimous, StrategyAllMustAgree:
+		return true
+	}
+	return false
+}
+
+// Aggregate is combine answers of asked providers, in the order they came.
+// disagreement tells that successful answers had different verdicts. The
+// error wraps ErrNoConsensus and the errors of failed providers.
+func Aggregate(strategy string, asked int, answers []Answer) (result bool, disagreement bool, err error) {
+	var yes, no int
+	var errs []error
+	first := -1
+	for i, a := range answers {
+		if a.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Provider, a.Err))
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if a.Result {
+			yes++
+		} else {
+			no++
+		}
+	}
+	disagreement = yes > 0 && no > 0
+
+	noConsensus := func(reason string) (bool, bool, error) {
+		return false, disagreement, errors.Join(fmt.Errorf("%w: %s", ErrNoConsensus, reason), errors.Join(errs...))
+	}
+
+	if yes+no == 0 {
+		return noConsensus("no provider answered")
+	}
+
+	switch strategy {
+	case StrategyFirstWins:
+		return answers[first].Result, disagreement, nil
+	case StrategyMajority:
+		if yes*2 > asked {
+			return true, disagreement, nil
+		}
+		if no*2 > asked {
+			return false, disagreement, nil
+		}
+		return noConsensus(fmt.Sprintf("%d of %d said true, %d false", yes, asked, no))
+	case StrategyUnanimous:
+		if no > 0 {
+			return false, disagreement, nil
+		}
+		if yes < asked {
+			return noConsensus(fmt.Sprintf("%d of %d providers answered", yes, asked))
+		}
+		return true, disagreement, nil
+	case StrategyAllMustAgree:
+		if yes+no < asked {
+			return noConsensus(fmt.Sprintf("%d of %d providers answered", yes+no, asked))
+		}
+		if disagreement {
+			return noConsensus("answers differ")
+		}
+		return yes > 0, disagreement, nil
+	}
+	return false, disagreement, fmt.Errorf("unknown strategy %q", strategy)
+}
@@ -0,0 +1,154 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cadastral-service/internal/config"
+)
+
+// headers of an inbound callback
+const (
+	CallbackTimestampHeader = "X-Callback-Timestamp"
+	CallbackSignatureHeader = "X-Callback-Signature"
+)
+
+const (
+	// defaultCallbackTimeout is how long a job may take when the provider does not say
+	defaultCallbackTimeout = 10 * time.Minute
+	// callbackTolerance is how old a signed callback may be, older ones may be replays
+	callbackTolerance = 5 * time.Minute
+)
+
+// ErrInvalidSignature is returned by ParseCallback for callbacks that are
+// not signed with the shared secret
+var ErrInvalidSignature = errors.New("invalid callback signature")
+
+// Callback is the verdict a provider delivered about a job, Error is set
+// when the provider could not check the request
+type Callback struct {
+	JobID  string
+	Result bool
+	Error  string
+}
+
+// CallbackProvider is a provider that answers by callback. Check submits
+// a job and returns its id, the verdict later arrives to the callback
+// endpoint of the service and is read by ParseCallback.
+type CallbackProvider interface {
+	Provider
+	// CallbackTimeout is how long a job may take before it is submitted again
+	CallbackTimeout() time.Duration
+	ParseCallback(header http.Header, body []byte) (Callback, error)
+}
+
+// HTTPCallbackProvider is an HTTPProvider in callback mode. The request
+// also carries callback_url; the provider answers 202 with the job id at
+// JobField, or 200 with the verdict when it is known at once. The callback
+// is a JSON object with the job id at JobField and the verdict at
+// ResultField, or an error string instead of the verdict.
+type HTTPCallbackProvider struct {
+	*HTTPProvider
+	callbackURL string
+}
+
+var _ CallbackProvider = (*HTTPCallbackProvider)(nil)
+
+// NewHTTPCallbackProvider is a provider that delivers verdicts to callbackURL
+func NewHTTPCallbackProvider(cfg config.ProviderConfig, callbackURL string) *HTTPCallbackProvider {
+	if cfg.JobField == "" {
+		cfg.JobField = "job_id"
+	}
+	if cfg.CallbackTimeout == 0 {
+		cfg.CallbackTimeout = defaultCallbackTimeout
+	}
+	return &HTTPCallbackProvider{
+		HTTPProvider: NewHTTPProvider(cfg),
+		callbackURL:  callbackURL,
+	}
+}
+
+// Check is submit the request as a job, Response.JobID is its id
+func (p *HTTPCallbackProvider) Check(ctx context.Context, req Request) (Response, error) {
+	fields := p.fields(req)
+	fields[p.field("callback_url")] = p.callbackURL
+
+	resp, err := p.send(ctx, fields)
+	if err != nil {
+		return resp, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		resp.JobID, err = p.jobID(resp.RawResponse)
+	case http.StatusOK:
+		resp.Result, err = p.result(resp.RawResponse)
+	default:
+		err = &StatusError{StatusCode: resp.StatusCode}
+	}
+	return resp, err
+}
+
+func (p *HTTPCallbackProvider) CallbackTimeout() time.Duration {
+	return p.cfg.CallbackTimeout
+}
+
+// ParseCallback is check the signature of a callback and read it
+func (p *HTTPCallbackProvider) ParseCallback(header http.Header, body []byte) (Callback, error) {
+	var cb Callback
+
+	timestamp, err := strconv.ParseInt(header.Get(CallbackTimestampHeader), 10, 64)
+	if err != nil || p.cfg.CallbackSecret == "" {
+		return cb, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > callbackTolerance || age < -callbackTolerance {
+		return cb, ErrInvalidSignature
+	}
+	expected := SignCallback(p.cfg.CallbackSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(CallbackSignatureHeader))) {
+		return cb, ErrInvalidSignature
+	}
+
+	if cb.JobID, err = p.jobID(body); err != nil {
+		return cb, err
+	}
+
+	// a failed job has an error instead of the verdict
+	if message, _ := lookup(body, "error"); message != nil && message != "" {
+		cb.Error = fmt.Sprint(message)
+		return cb, nil
+	}
+	cb.Result, err = p.result(body)
+	return cb, err
+}
+
+// jobID is the string at the dotted JobField path of a body
+func (p *HTTPCallbackProvider) jobID(body []byte) (string, error) {
+	value, err := lookup(body, p.cfg.JobField)
+	if err != nil {
+		return "", err
+	}
+
+	id, ok := value.(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("invalid external server response: %s is not a job id", p.cfg.JobField)
+	}
+	return id, nil
+}
+
+// SignCallback is HMAC-SHA256 of "timestamp.body" with the shared secret,
+// providers send it in CallbackSignatureHeader
+func SignCallback(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
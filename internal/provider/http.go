@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cadastral-service/internal/config"
+)
+
+// maxResponseSize is how much of an answer is read and logged
+const maxResponseSize = 1 << 20
+
+// HTTPProvider is a JSON-over-HTTP service. The request is a JSON object
+// with cadastral_number, latitude and longitude, renamed by RequestFields;
+// the verdict is the boolean at ResultField of the answer.
+type HTTPProvider struct {
+	cfg    config.ProviderConfig
+	client *http.Client
+}
+
+var _ Provider = (*HTTPProvider)(nil)
+
+func NewHTTPProvider(cfg config.ProviderConfig) *HTTPProvider {
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.ResultField == "" {
+		cfg.ResultField = "result"
+	}
+	return &HTTPProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.cfg.Name
+}
+
+// Check is send the request and read the verdict, any status but 200 is an error
+func (p *HTTPProvider) Check(ctx context.Context, req Request) (Response, error) {
+	resp, err := p.send(ctx, p.fields(req))
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	resp.Result, err = p.result(resp.RawResponse)
+	return resp, err
+}
+
+// fields is the JSON object sent for a request
+func (p *HTTPProvider) fields(req Request) map[string]interface{} {
+	return map[string]interface{}{
+		p.field("cadastral_number"): req.CadastralNumber,
+		p.field("latitude"):         req.Latitude,
+		p.field("longitude"):        req.Longitude,
+	}
+}
+
+// send is send fields and read the answer whatever its status
+func (p *HTTPProvider) send(ctx context.Context, fields map[string]interface{}) (Response, error) {
+	var resp Response
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return resp, err
+	}
+	resp.RawRequest = body
+
+	httpReq, err := http.NewRequestWithContext(ctx, p.cfg.Method, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for name, value := range p.cfg.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+
+	resp.StatusCode = httpResp.StatusCode
+	resp.RawResponse, err = io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize))
+	return resp, err
+}
+
+// field is the name a provider uses for one of our request fields
+func (p *HTTPProvider) field(name string) string {
+	if renamed := p.cfg.RequestFields[name]; renamed != "" {
+		return renamed
+	}
+	return name
+}
+
+// result is the boolean at the dotted ResultField path of the answer
+func (p *HTTPProvider) result(body []byte) (bool, error) {
+	value, err := lookup(body, p.cfg.ResultField)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("invalid external server response: %s is not a boolean", p.cfg.ResultField)
+	}
+	return result, nil
+}
+
+// lookup is the value at a dotted path of a JSON body, nil when it is absent
+func lookup(body []byte, path string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("invalid external server response: %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid external server response: no %s field", path)
+		}
+		value = object[key]
+	}
+	return value, nil
+}
@@ -0,0 +1,42 @@
+// Package provider is the external services that verify a parcel. Each
+// provider has a name, requests record which one answered them.
+package provider
+
+import (
+	"context"
+	"fmt"
+)
+
+// Request is what is checked: a parcel and a point that should lie on it
+type Request struct {
+	CadastralNumber string
+	Latitude        float64
+	Longitude       float64
+}
+
+// Response is the verdict of a provider with the raw exchange kept in
+// query_history. A failed check still returns what was sent and received.
+type Response struct {
+	Result bool
+	// JobID is set instead of Result when the provider took the request as
+	// a job, the verdict comes later by callback
+	JobID       string
+	StatusCode  int
+	RawRequest  []byte
+	RawResponse []byte
+}
+
+// Provider is one external verification service
+type Provider interface {
+	Name() string
+	Check(ctx context.Context, req Request) (Response, error)
+}
+
+// StatusError is an answer of a provider with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("external server returned status: %d", e.StatusCode)
+}
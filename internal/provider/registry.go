@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cadastral-service/internal/config"
+)
+
+// Registry is the named providers, one of them is the default
+type Registry struct {
+	providers   map[string]Provider
+	names       []string
+	defaultName string
+}
+
+// NewRegistry is empty registry, the first registered provider becomes the default
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// FromConfig is registry of the configured HTTP providers. Without any the
+// only provider is "default" at ExternalServerURL, or the built-in emulation.
+// Providers in callback mode call back to CallbackBaseURL.
+func FromConfig(cfg *config.Config) (*Registry, error) {
+	callbackBase := strings.TrimSuffix(cfg.CallbackBaseURL, "/")
+	if callbackBase == "" {
+		callbackBase = "http://localhost:" + cfg.Port
+	}
+
+	providers := cfg.Providers
+	if len(providers) == 0 {
+		url := cfg.ExternalServerURL
+		if url == "" {
+			url = "http://localhost:" + cfg.Port + "/api/result"
+		}
+		providers = []config.ProviderConfig{{Name: "default", URL: url}}
+	}
+
+	r := NewRegistry()
+	for _, pc := range providers {
+		if pc.URL == "" {
+			return nil, fmt.Errorf("provider %q has no url", pc.Name)
+		}
+
+		var p Provider
+		switch pc.Mode {
+		case "", config.ProviderSync:
+			p = NewHTTPProvider(pc)
+		case config.ProviderCallback:
+			if pc.CallbackSecret == "" {
+				return nil, fmt.Errorf("provider %q in callback mode has no callback secret", pc.Name)
+			}
+			p = NewHTTPCallbackProvider(pc, callbackBase+"/api/v1/callbacks/"+url.PathEscape(pc.Name))
+		default:
+			return nil, fmt.Errorf("provider %q has unknown mode %q", pc.Name, pc.Mode)
+		}
+
+		if err := r.Register(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.DefaultProvider != "" {
+		if err := r.SetDefault(cfg.DefaultProvider); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register is add a provider, names are unique
+func (r *Registry) Register(p Provider) error {
+	name := p.Name()
+	if name == "" || strings.Contains(name, ",") {
+		return fmt.Errorf("provider name %q must be non-empty and without commas", name)
+	}
+	if _, ok := r.providers[name]; ok {
+		return fmt.Errorf("provider %q is registered twice", name)
+	}
+
+	r.providers[name] = p
+	r.names = append(r.names, name)
+	if r.defaultName == "" {
+		r.defaultName = name
+	}
+	return nil
+}
+
+// SetDefault is pick the provider of requests that do not name one
+func (r *Registry) SetDefault(name string) error {
+	if _, ok := r.providers[name]; !ok {
+		return fmt.Errorf("unknown default provider %q", name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+// Get is return a provider by name, the default one for an empty name
+func (r *Registry) Get(name string) (Provider, bool) {
+	if name == "" {
+		name = r.defaultName
+	}
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Default is name of the default provider
+func (r *Registry) Default() string {
+	return r.defaultName
+}
+
+// Names is every provider in the order of registration
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"cadastral-service/internal/models"
+)
+
+// RecordQueryAttempt is write one external server call to query_history
+func (r *Repository) RecordQueryAttempt(ctx context.Context, attempt *models.QueryAttempt) error {
+	queryStr := `
+		INSERT INTO query_history (id, query_id, provider, cadastral_number, attempt, status, request_data,
+			response_data, status_code, latency_ms, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	_, err := r.db.ExecContext(ctx, queryStr,
+		attempt.ID,
+		attempt.QueryID,
+		nullString(attempt.Provider),
+		attempt.CadastralNumber,
+		attempt.Attempt,
+		attempt.Status,
+		string(attempt.Request),
+		nullString(string(attempt.Response)),
+		nullInt(attempt.StatusCode),
+		attempt.LatencyMs,
+		nullString(attempt.Error),
+		attempt.CreatedAt,
+	)
+
+	return err
+}
+
+// GetQueryAttempts is return external server calls of one request, oldest first
+func (r *Repository) GetQueryAttempts(ctx context.Context, queryID string) ([]models.QueryAttempt, error) {
+	queryStr := `
+		SELECT id, query_id, provider, cadastral_number, attempt, status, request_data, response_data,
+			status_code, latency_ms, error, created_at
+		FROM query_history
+		WHERE query_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.QueryContext(ctx, queryStr, queryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.QueryAttempt
+	for rows.Next() {
+		var a models.QueryAttempt
+		var request string
+		var provider, response, errMsg sql.NullString
+		var statusCode, latency sql.NullInt64
+		var attempt sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.QueryID, &provider, &a.CadastralNumber, &attempt, &a.Status, &request, &response,
+			&statusCode, &latency, &errMsg, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Provider = provider.String
+		a.Attempt = int(attempt.Int64)
+		a.Request = json.RawMessage(request)
+		if response.Valid {
+			a.Response = json.RawMessage(response.String)
+		}
+		a.StatusCode = int(statusCode.Int64)
+		a.LatencyMs = latency.Int64
+		a.Error = errMsg.String
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// SaveProviderAnswers is replace answers of the providers of a request with
+// the last round and set its disagreement flag
+func (r *Repository) SaveProviderAnswers(ctx context.Context, queryID string, answers []models.ProviderAnswer, disagreement bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM query_provider_answers WHERE query_id = $1`, queryID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO query_provider_answers (query_id, provider, result, cached, error, latency_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, a := range answers {
+		if _, err := stmt.ExecContext(ctx, queryID, a.Provider, a.Result, a.Cached, nullString(a.Error), a.LatencyMs, a.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE queries SET disagreement = $1 WHERE id = $2`, disagreement, queryID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetProviderAnswers is return answers of the providers of a request
+func (r *Repository) GetProviderAnswers(ctx context.Context, queryID string) ([]models.ProviderAnswer, error) {
+	queryStr := `
+		SELECT query_id, provider, result, cached, error, latency_ms, created_at
+		FROM query_provider_answers
+		WHERE query_id = $1
+		ORDER BY created_at, provider
+	`
+
+	rows, err := r.db.QueryContext(ctx, queryStr, queryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var answers []models.ProviderAnswer
+	for rows.Next() {
+		var a models.ProviderAnswer
+		var errMsg sql.NullString
+		if err := rows.Scan(&a.QueryID, &a.Provider, &a.Result, &a.Cached, &errMsg, &a.LatencyMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Error = errMsg.String
+		answers = append(answers, a)
+	}
+
+	return answers, rows.Err()
+}
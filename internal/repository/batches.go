@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"cadastral-service/internal/models"
+)
+
+// CreateBatch is save a batch and all its requests in one transaction
+func (r *Repository) CreateBatch(ctx context.Context, batch *models.Batch, queries []*models.Query) error {
+	return r.CreateImport(ctx, batch, queries, nil)
+}
+
+// CreateImport is save a batch, its requests and the import report in one transaction
+func (r *Repository) CreateImport(ctx context.Context, batch *models.Batch, queries []*models.Query, report []models.ImportRow) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO batches (id, user_id, total, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, batch.ID, nullString(batch.UserID), batch.Total, batch.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if len(queries) > 0 {
+		stmt, err := tx.PrepareContext(ctx, insertQuery)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, query := range queries {
+			if _, err := stmt.ExecContext(ctx, insertQueryArgs(query)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(report) > 0 {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO import_rows (batch_id, row_number, cadastral_number, latitude, longitude, query_id, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, row := range report {
+			_, err := stmt.ExecContext(ctx,
+				batch.ID,
+				row.Row,
+				row.CadastralNumber,
+				row.Latitude,
+				row.Longitude,
+				nullString(row.QueryID),
+				nullString(row.Error),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetBatch is return a batch, ErrNotFound if there is none
+func (r *Repository) GetBatch(ctx context.Context, id string) (*models.Batch, error) {
+	queryStr := `SELECT id, user_id, total, created_at FROM batches WHERE id = $1`
+
+	var batch models.Batch
+	var userID sql.NullString
+	err := r.db.QueryRowContext(ctx, queryStr, id).Scan(&batch.ID, &userID, &batch.Total, &batch.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	batch.UserID = userID.String
+	return &batch, nil
+}
+
+// CountBatchStatuses is return number of batch requests in every status
+func (r *Repository) CountBatchStatuses(ctx context.Context, batchID string) (map[string]int, error) {
+	queryStr := `
+		SELECT status, COUNT(*)
+		FROM queries
+		WHERE batch_id = $1
+		GROUP BY status
+	`
+
+	rows, err := r.db.QueryContext(ctx, queryStr, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// GetImportRows is return the import report of a batch ordered by row
+func (r *Repository) GetImportRows(ctx context.Context, batchID string) ([]models.ImportRow, error) {
+	queryStr := `
+		SELECT batch_id, row_number, cadastral_number, latitude, longitude, query_id, error
+		FROM import_rows
+		WHERE batch_id = $1
+		ORDER BY row_number
+	`
+
+	rows, err := r.db.QueryContext(ctx, queryStr, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []models.ImportRow
+	for rows.Next() {
+		var row models.ImportRow
+		var cadastralNumber, latitude, longitude, queryID, errMsg sql.NullString
+		err := rows.Scan(&row.BatchID, &row.Row, &cadastralNumber, &latitude, &longitude, &queryID, &errMsg)
+		if err != nil {
+			return nil, err
+		}
+		row.CadastralNumber = cadastralNumber.String
+		row.Latitude = latitude.String
+		row.Longitude = longitude.String
+		row.QueryID = queryID.String
+		row.Error = errMsg.String
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
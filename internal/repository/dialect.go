@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dialect is the SQL flavour of the database behind Repository.
+// Statements are written for Postgres and rewritten for the others.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// sqliteTimeLayout is how timestamps are kept in SQLite: fixed width UTC
+// text, so comparing strings compares times
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000000-07:00"
+
+// sqliteNow is CURRENT_TIMESTAMP in sqliteTimeLayout, SQLite gives only
+// milliseconds and the rest is padded with zeros
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now')`
+
+var (
+	positionalParam = regexp.MustCompile(`\$(\d+)`)
+	currentTime     = regexp.MustCompile(`\bCURRENT_TIMESTAMP\b`)
+	greatest        = regexp.MustCompile(`\bGREATEST\(`)
+	// SQLite has a single writer, claims can not race and need no row locks
+	rowLock = regexp.MustCompile(`\s+FOR UPDATE(\s+OF\s+\w+)?\s+SKIP LOCKED`)
+)
+
+// rebind is rewrite a Postgres statement for the dialect
+func (d Dialect) rebind(query string) string {
+	if d != SQLite {
+		return query
+	}
+
+	query = positionalParam.ReplaceAllString(query, "?$1")
+	query = currentTime.ReplaceAllString(query, sqliteNow)
+	query = greatest.ReplaceAllString(query, "MAX(")
+	return rowLock.ReplaceAllString(query, "")
+}
+
+// args is convert arguments for the dialect, SQLite gets times as sqliteTimeLayout
+func (d Dialect) args(args []interface{}) []interface{} {
+	if d != SQLite {
+		return args
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC().Format(sqliteTimeLayout)
+		case *time.Time:
+			if v == nil {
+				converted[i] = nil
+			} else {
+				converted[i] = v.UTC().Format(sqliteTimeLayout)
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// isUniqueViolation is tell whether err is a violated unique constraint
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == uniqueViolation
+	}
+
+	// SQLite driver errors carry the extended result code
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		return coded.Code() == sqliteConstraintUnique || coded.Code() == sqliteConstraintPrimaryKey
+	}
+	return false
+}
+
+// extended result codes of SQLite
+const (
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// dialectDB is *sql.DB which rewrites statements and arguments for the dialect
+type dialectDB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (db *dialectDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *dialectDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *dialectDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(query), db.dialect.args(args)...)
+}
+
+func (db *dialectDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dialectTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+type dialectTx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *dialectTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), tx.dialect.args(args)...)
+}
+
+func (tx *dialectTx) PrepareContext(ctx context.Context, query string) (*dialectStmt, error) {
+	stmt, err := tx.Tx.PrepareContext(ctx, tx.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &dialectStmt{Stmt: stmt, dialect: tx.dialect}, nil
+}
+
+type dialectStmt struct {
+	*sql.Stmt
+	dialect Dialect
+}
+
+func (s *dialectStmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	return s.Stmt.ExecContext(ctx, s.dialect.args(args)...)
+}
@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"cmp"
+	"strings"
+	"time"
+
+	"cadastral-service/internal/models"
+)
+
+// values of QueryFilter.Result
+const (
+	ResultAny   = ""
+	ResultTrue  = "true"
+	ResultFalse = "false"
+	// ResultNull is requests without a result yet or failed ones
+	ResultNull = "null"
+)
+
+// sortColumns is the whitelist of sortable fields, only these names ever
+// reach the ORDER BY clause
+var sortColumns = map[string]string{
+	"created_at":       "created_at",
+	"completed_at":     "completed_at",
+	"status":           "status",
+	"cadastral_number": "cadastral_number",
+	"attempts":         "attempts",
+}
+
+// IsSortField is tell whether the history can be sorted by the field
+func IsSortField(field string) bool {
+	_, ok := sortColumns[field]
+	return ok
+}
+
+// QueryFilter is conditions of history listing and export,
+// empty fields do not filter
+type QueryFilter struct {
+	UserID          string
+	CadastralNumber string
+	// CadastralPrefix matches the beginning of the number, e.g. "77" or "77:01"
+	CadastralPrefix string
+	Statuses        []string
+	Result          string
+	// ranges are [from, to), zero time is an open end
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+	CompletedFrom time.Time
+	CompletedTo   time.Time
+	// Sort is one of sortColumns, created_at by default
+	Sort string
+	// Desc is descending order
+	Desc bool
+}
+
+// where is build WHERE clause of the filter, placeholders are numbered
+// after the args already collected
+func (f QueryFilter) where(args []interface{}) (string, []interface{}) {
+	var conditions []string
+
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", placeholder(len(args))))
+	}
+
+	if f.UserID != "" {
+		add("user_id = ?", f.UserID)
+	}
+	if f.CadastralNumber != "" {
+		add("cadastral_number = ?", f.CadastralNumber)
+	}
+	if f.CadastralPrefix != "" {
+		add(`cadastral_number LIKE ? ESCAPE '\'`, escapeLike(f.CadastralPrefix)+"%")
+	}
+
+	if len(f.Statuses) > 0 {
+		placeholders := make([]string, len(f.Statuses))
+		for i, status := range f.Statuses {
+			args = append(args, status)
+			placeholders[i] = placeholder(len(args))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	switch f.Result {
+	case ResultTrue:
+		conditions = append(conditions, "result = TRUE")
+	case ResultFalse:
+		conditions = append(conditions, "result = FALSE")
+	case ResultNull:
+		conditions = append(conditions, "result IS NULL")
+	}
+
+	if !f.CreatedFrom.IsZero() {
+		add("created_at >= ?", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		add("created_at < ?", f.CreatedTo)
+	}
+	if !f.CompletedFrom.IsZero() {
+		add("completed_at >= ?", f.CompletedFrom)
+	}
+	if !f.CompletedTo.IsZero() {
+		add("completed_at < ?", f.CompletedTo)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy is ORDER BY clause of the filter, id breaks ties so pages are stable
+func (f QueryFilter) orderBy() string {
+	column, ok := sortColumns[f.Sort]
+	if !ok {
+		column = "created_at"
+	}
+
+	direction := "ASC"
+	if f.Desc {
+		direction = "DESC"
+	}
+
+	nulls := ""
+	if column == "completed_at" {
+		// unfinished requests go last whatever the direction
+		nulls = " NULLS LAST"
+	}
+
+	return " ORDER BY " + column + " " + direction + nulls + ", id " + direction
+}
+
+// escapeLike is escape wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Match is the in-memory counterpart of where
+func (f QueryFilter) Match(q *models.Query) bool {
+	if f.UserID != "" && q.UserID != f.UserID {
+		return false
+	}
+	if f.CadastralNumber != "" && q.CadastralNumber != f.CadastralNumber {
+		return false
+	}
+	if f.CadastralPrefix != "" && !strings.HasPrefix(q.CadastralNumber, f.CadastralPrefix) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if q.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch f.Result {
+	case ResultTrue:
+		if q.Result == nil || !*q.Result {
+			return false
+		}
+	case ResultFalse:
+		if q.Result == nil || *q.Result {
+			return false
+		}
+	case ResultNull:
+		if q.Result != nil {
+			return false
+		}
+	}
+
+	if !f.CreatedFrom.IsZero() && q.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && !q.CreatedAt.Before(f.CreatedTo) {
+		return false
+	}
+	// NULL completed_at never matches a range, as in SQL
+	if !f.CompletedFrom.IsZero() && (q.CompletedAt == nil || q.CompletedAt.Before(f.CompletedFrom)) {
+		return false
+	}
+	if !f.CompletedTo.IsZero() && (q.CompletedAt == nil || !q.CompletedAt.Before(f.CompletedTo)) {
+		return false
+	}
+
+	return true
+}
+
+// Compare is the in-memory counterpart of orderBy, negative when a goes first
+func (f QueryFilter) Compare(a, b *models.Query) int {
+	var c int
+	switch f.Sort {
+	case "completed_at":
+		switch {
+		case a.CompletedAt == nil && b.CompletedAt == nil:
+		case a.CompletedAt == nil:
+			return 1
+		case b.CompletedAt == nil:
+			return -1
+		default:
+			c = a.CompletedAt.Compare(*b.CompletedAt)
+		}
+	case "status":
+		c = strings.Compare(a.Status, b.Status)
+	case "cadastral_number":
+		c = strings.Compare(a.CadastralNumber, b.CadastralNumber)
+	case "attempts":
+		c = cmp.Compare(a.Attempts, b.Attempts)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if f.Desc {
+		return -c
+	}
+	return c
+}
+
+// After is tell whether a request follows the key in (created_at, id) order
+// of the filter, the in-memory counterpart of the keyset condition
+func (f QueryFilter) After(q *models.Query, key *Keyset) bool {
+	c := q.CreatedAt.Compare(key.CreatedAt)
+	if c == 0 {
+		c = strings.Compare(q.ID, key.ID)
+	}
+	if f.Desc {
+		return c < 0
+	}
+	return c > 0
+}
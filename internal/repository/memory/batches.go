@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+// CreateBatch is save a batch and all its requests at once
+func (s *Store) CreateBatch(ctx context.Context, batch *models.Batch, queries []*models.Query) error {
+	return s.CreateImport(ctx, batch, queries, nil)
+}
+
+// CreateImport is save a batch, its requests and the import report at once,
+// nothing is saved when any of them conflicts
+func (s *Store) CreateImport(ctx context.Context, batch *models.Batch, queries []*models.Query, report []models.ImportRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.batches[batch.ID]; ok {
+		return repository.ErrDuplicate
+	}
+	for _, query := range queries {
+		if _, ok := s.queries[query.ID]; ok {
+			return repository.ErrDuplicate
+		}
+	}
+
+	b := *batch
+	s.batches[batch.ID] = &b
+	for _, query := range queries {
+		s.insertQuery(query)
+	}
+	if len(report) > 0 {
+		s.importRows[batch.ID] = slices.Clone(report)
+	}
+	return nil
+}
+
+// GetBatch is return a batch, ErrNotFound if there is none
+func (s *Store) GetBatch(ctx context.Context, id string) (*models.Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	b := *batch
+	return &b, nil
+}
+
+// CountBatchStatuses is return number of batch requests in every status
+func (s *Store) CountBatchStatuses(ctx context.Context, batchID string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, rec := range s.queries {
+		if rec.query.BatchID == batchID {
+			counts[rec.query.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// GetImportRows is return the import report of a batch ordered by row
+func (s *Store) GetImportRows(ctx context.Context, batchID string) ([]models.ImportRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := slices.Clone(s.importRows[batchID])
+	slices.SortFunc(report, func(a, b models.ImportRow) int {
+		return a.Row - b.Row
+	})
+	return report, nil
+}
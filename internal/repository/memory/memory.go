@@ -0,0 +1,572 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+// Store is repository.Store kept in process memory, for tests and local
+// runs without a database. Everything is lost on exit.
+type Store struct {
+	mu         sync.Mutex
+	queries    map[string]*queryRecord
+	users      map[string]*models.User
+	deliveries map[string]*deliveryRecord
+	attempts   map[string][]models.WebhookAttempt
+	calls      map[string][]models.QueryAttempt
+	answers    map[string][]models.ProviderAnswer
+	batches    map[string]*models.Batch
+	importRows map[string][]models.ImportRow
+	callbacks  map[string]models.JobCallback
+}
+
+// queryRecord is a request with the queue state that is not part of the model
+type queryRecord struct {
+	query       models.Query
+	lockedUntil *time.Time
+}
+
+var _ repository.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		queries:    make(map[string]*queryRecord),
+		users:      make(map[string]*models.User),
+		deliveries: make(map[string]*deliveryRecord),
+		attempts:   make(map[string][]models.WebhookAttempt),
+		calls:      make(map[string][]models.QueryAttempt),
+		answers:    make(map[string][]models.ProviderAnswer),
+		batches:    make(map[string]*models.Batch),
+		importRows: make(map[string][]models.ImportRow),
+		callbacks:  make(map[string]models.JobCallback),
+	}
+}
+
+// CreateQuery is create a new request
+func (s *Store) CreateQuery(ctx context.Context, query *models.Query) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertQuery(query)
+}
+
+func (s *Store) insertQuery(query *models.Query) error {
+	if _, ok := s.queries[query.ID]; ok {
+		return repository.ErrDuplicate
+	}
+	rec := &queryRecord{query: cloneQuery(query)}
+	rec.query.ParseCadastral()
+	s.queries[query.ID] = rec
+	return nil
+}
+
+// UpdateQuery is update a status of request still processing under jobID
+func (s *Store) UpdateQuery(ctx context.Context, id, jobID string, status string, result *bool, cached bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok || !processing(rec, jobID) {
+		return false, nil
+	}
+
+	rec.query.Status = status
+	rec.query.Result = cloneBool(result)
+	rec.query.Cached = cached
+	rec.query.CompletedAt = now()
+	rec.query.ExternalJobID = ""
+	rec.query.DeadlineAt = nil
+	rec.lockedUntil = nil
+	return true, nil
+}
+
+// FailQuery is finish a request still processing under jobID with an error
+func (s *Store) FailQuery(ctx context.Context, id, jobID string, status string, lastError string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok || !processing(rec, jobID) {
+		return false, nil
+	}
+
+	rec.query.Status = status
+	rec.query.LastError = lastError
+	rec.query.CompletedAt = now()
+	rec.query.ExternalJobID = ""
+	rec.query.DeadlineAt = nil
+	rec.lockedUntil = nil
+	return true, nil
+}
+
+// RetryQuery is put a failed request still processing under jobID back to
+// the queue until nextAttemptAt
+func (s *Store) RetryQuery(ctx context.Context, id, jobID string, lastError string, nextAttemptAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok || !processing(rec, jobID) {
+		return false, nil
+	}
+
+	rec.query.Status = models.StatusPending
+	rec.query.LastError = lastError
+	rec.query.NextAttemptAt = &nextAttemptAt
+	rec.query.ExternalJobID = ""
+	rec.query.DeadlineAt = nil
+	rec.lockedUntil = nil
+	return true, nil
+}
+
+// CancelQuery is cancel a pending or processing request
+func (s *Store) CancelQuery(ctx context.Context, id, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok || (userID != "" && rec.query.UserID != userID) {
+		return false, nil
+	}
+	if rec.query.Status != models.StatusPending && rec.query.Status != models.StatusProcessing {
+		return false, nil
+	}
+
+	rec.query.Status = models.StatusCancelled
+	rec.query.CompletedAt = now()
+	rec.query.ExternalJobID = ""
+	rec.query.DeadlineAt = nil
+	rec.lockedUntil = nil
+	return true, nil
+}
+
+// RequeueQuery is reset a dead-lettered, failed or timed out request to pending
+func (s *Store) RequeueQuery(ctx context.Context, id, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok || (userID != "" && rec.query.UserID != userID) {
+		return false, nil
+	}
+	switch rec.query.Status {
+	case models.StatusDeadLetter, models.StatusFailed, models.StatusTimedOut:
+	default:
+		return false, nil
+	}
+
+	rec.query.Status = models.StatusPending
+	rec.query.Result = nil
+	rec.query.Cached = false
+	rec.query.Disagreement = false
+	rec.query.Attempts = 0
+	rec.query.NextAttemptAt = nil
+	rec.query.CompletedAt = nil
+	rec.query.ExternalJobID = ""
+	rec.query.DeadlineAt = nil
+	return true, nil
+}
+
+// ClaimQuery is take the oldest due request for processing, nil when there is none
+func (s *Store) ClaimQuery(ctx context.Context, lease time.Duration) (*models.Query, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := time.Now()
+	var oldest *queryRecord
+	for _, rec := range s.queries {
+		if !claimable(rec, t) {
+			continue
+		}
+		if oldest == nil || rec.query.CreatedAt.Before(oldest.query.CreatedAt) {
+			oldest = rec
+		}
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+
+	lockedUntil := t.Add(lease)
+	oldest.query.Status = models.StatusProcessing
+	// a request whose callback did not come is not attempted again by the claim
+	if oldest.query.ExternalJobID == "" {
+		oldest.query.Attempts++
+	}
+	oldest.lockedUntil = &lockedUntil
+
+	query := cloneQuery(&oldest.query)
+	query.LockedUntil = &lockedUntil
+	return &query, nil
+}
+
+// processing is tell whether nobody stored an outcome of the request
+// since it was claimed or submitted as jobID
+func processing(rec *queryRecord, jobID string) bool {
+	return rec.query.Status == models.StatusProcessing && rec.query.ExternalJobID == jobID
+}
+
+func claimable(rec *queryRecord, t time.Time) bool {
+	switch rec.query.Status {
+	case models.StatusPending:
+		return rec.query.NextAttemptAt == nil || !rec.query.NextAttemptAt.After(t)
+	case models.StatusProcessing:
+		return rec.lockedUntil == nil || rec.lockedUntil.Before(t)
+	}
+	return false
+}
+
+// ReleaseQuery is return a claimed request back to the queue, claimable
+// again from nextAttemptAt or at once when it is zero
+func (s *Store) ReleaseQuery(ctx context.Context, id string, nextAttemptAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.queries[id]; ok && rec.query.Status == models.StatusProcessing {
+		rec.query.Status = models.StatusPending
+		rec.lockedUntil = nil
+		rec.query.ExternalJobID = ""
+		rec.query.DeadlineAt = nil
+		rec.query.NextAttemptAt = nil
+		if !nextAttemptAt.IsZero() {
+			rec.query.NextAttemptAt = &nextAttemptAt
+		}
+		if rec.query.Attempts > 0 {
+			rec.query.Attempts--
+		}
+	}
+	return nil
+}
+
+// AwaitCallback is keep a request in processing until a callback about jobID
+func (s *Store) AwaitCallback(ctx context.Context, id, jobID string, until, deadline time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.queries[id]; ok && rec.query.Status == models.StatusProcessing {
+		rec.query.ExternalJobID = jobID
+		rec.lockedUntil = &until
+		rec.query.DeadlineAt = nil
+		if !deadline.IsZero() {
+			rec.query.DeadlineAt = &deadline
+		}
+	}
+	return nil
+}
+
+// GetQueryByJob is return the request waiting for a callback about a job
+func (s *Store) GetQueryByJob(ctx context.Context, provider, jobID string) (*models.Query, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rec := range s.queries {
+		if rec.query.Provider == provider && rec.query.ExternalJobID == jobID && jobID != "" {
+			query := cloneQuery(&rec.query)
+			return &query, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// ParkCallback is keep a callback about a job no request waits for yet
+func (s *Store) ParkCallback(ctx context.Context, cb *models.JobCallback, expire time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, parked := range s.callbacks {
+		if parked.ReceivedAt.Before(expire) {
+			delete(s.callbacks, key)
+		}
+	}
+
+	parked := *cb
+	parked.Body = slices.Clone(cb.Body)
+	s.callbacks[cb.Provider+"\x00"+cb.JobID] = parked
+	return nil
+}
+
+// TakeCallback is remove and return the parked callback about a job
+func (s *Store) TakeCallback(ctx context.Context, provider, jobID string) (*models.JobCallback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := provider + "\x00" + jobID
+	cb, ok := s.callbacks[key]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	delete(s.callbacks, key)
+	return &cb, nil
+}
+
+// CountPendingQueries is return number of requests waiting in the queue
+func (s *Store) CountPendingQueries(ctx context.Context, userID string) (int, error) {
+	return s.CountQueries(ctx, repository.QueryFilter{
+		UserID:   userID,
+		Statuses: []string{models.StatusPending},
+	})
+}
+
+// GetQueryByID is return one request, ErrNotFound if there is none
+func (s *Store) GetQueryByID(ctx context.Context, id string) (*models.Query, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queries[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	query := cloneQuery(&rec.query)
+	return &query, nil
+}
+
+// GetQueries is return one page of requests matching the filter
+func (s *Store) GetQueries(ctx context.Context, filter repository.QueryFilter, page, limit int) ([]models.Query, error) {
+	queries := s.selectQueries(filter, nil)
+
+	offset := (page - 1) * limit
+	if offset >= len(queries) {
+		return nil, nil
+	}
+	return queries[offset:min(offset+limit, len(queries))], nil
+}
+
+// GetQueriesPage is return up to limit requests next to the key
+func (s *Store) GetQueriesPage(ctx context.Context, filter repository.QueryFilter, key *repository.Keyset, limit int) ([]models.Query, bool, error) {
+	filter.Sort = "created_at"
+
+	backwards := key != nil && key.Before
+	order := filter
+	if backwards {
+		order.Desc = !order.Desc
+	}
+
+	var where func(*models.Query) bool
+	if key != nil {
+		where = func(q *models.Query) bool { return order.After(q, key) }
+	}
+	queries := s.selectQueries(order, where)
+
+	more := false
+	if len(queries) > limit {
+		queries, more = queries[:limit], true
+	}
+	if backwards {
+		slices.Reverse(queries)
+	}
+	return queries, more, nil
+}
+
+// CountQueries is number of requests matching the filter
+func (s *Store) CountQueries(ctx context.Context, filter repository.QueryFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, rec := range s.queries {
+		if filter.Match(&rec.query) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetQueriesByCadastral is return request by cadastral number, newest first
+func (s *Store) GetQueriesByCadastral(ctx context.Context, cadastralNumber, userID string) ([]models.Query, error) {
+	return s.selectQueries(repository.QueryFilter{
+		UserID:          userID,
+		CadastralNumber: cadastralNumber,
+		Desc:            true,
+	}, nil), nil
+}
+
+// StreamQueries is call fn for every request matching the filter in its order
+func (s *Store) StreamQueries(ctx context.Context, filter repository.QueryFilter, fn func(*models.Query) error) error {
+	for _, query := range s.selectQueries(filter, nil) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectQueries is copies of requests matching the filter and where, in the filter order
+func (s *Store) selectQueries(filter repository.QueryFilter, where func(*models.Query) bool) []models.Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queries []models.Query
+	for _, rec := range s.queries {
+		if filter.Match(&rec.query) && (where == nil || where(&rec.query)) {
+			queries = append(queries, cloneQuery(&rec.query))
+		}
+	}
+
+	slices.SortFunc(queries, func(a, b models.Query) int {
+		return filter.Compare(&a, &b)
+	})
+	return queries
+}
+
+// RecordQueryAttempt is write one external server call to the log
+func (s *Store) RecordQueryAttempt(ctx context.Context, attempt *models.QueryAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := *attempt
+	a.Request = slices.Clone(attempt.Request)
+	a.Response = slices.Clone(attempt.Response)
+	s.calls[a.QueryID] = append(s.calls[a.QueryID], a)
+	return nil
+}
+
+// GetQueryAttempts is return external server calls of one request, oldest first
+func (s *Store) GetQueryAttempts(ctx context.Context, queryID string) ([]models.QueryAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.calls[queryID]), nil
+}
+
+// SaveProviderAnswers is replace answers of the providers of a request with
+// the last round and set its disagreement flag
+func (s *Store) SaveProviderAnswers(ctx context.Context, queryID string, answers []models.ProviderAnswer, disagreement bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := make([]models.ProviderAnswer, len(answers))
+	for i, a := range answers {
+		a.QueryID = queryID
+		a.Result = cloneBool(a.Result)
+		saved[i] = a
+	}
+	s.answers[queryID] = saved
+
+	if rec, ok := s.queries[queryID]; ok {
+		rec.query.Disagreement = disagreement
+	}
+	return nil
+}
+
+// GetProviderAnswers is return answers of the providers of a request
+func (s *Store) GetProviderAnswers(ctx context.Context, queryID string) ([]models.ProviderAnswer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var answers []models.ProviderAnswer
+	for _, a := range s.answers[queryID] {
+		a.Result = cloneBool(a.Result)
+		answers = append(answers, a)
+	}
+	slices.SortFunc(answers, func(a, b models.ProviderAnswer) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Provider, b.Provider)
+	})
+	return answers, nil
+}
+
+// CreateUser is create a new user, ErrDuplicate when the name is taken
+func (s *Store) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return repository.ErrDuplicate
+		}
+	}
+	if _, ok := s.users[user.ID]; ok {
+		return repository.ErrDuplicate
+	}
+
+	u := *user
+	s.users[user.ID] = &u
+	return nil
+}
+
+// GetUserByUsername is return user by name, ErrNotFound if there is none
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			u := *user
+			return &u, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// GetUserByID is return user by ID, ErrNotFound if there is none
+func (s *Store) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	u := *user
+	return &u, nil
+}
+
+// UpdateUserWebhook is set callback URL and signing secret of a user
+func (s *Store) UpdateUserWebhook(ctx context.Context, userID, url, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		user.WebhookURL = url
+		user.WebhookSecret = secret
+	}
+	return nil
+}
+
+// cloneQuery is copy a request so callers never share memory with the store
+func cloneQuery(q *models.Query) models.Query {
+	c := *q
+	c.Result = cloneBool(q.Result)
+	c.CompletedAt = cloneTime(q.CompletedAt)
+	c.NextAttemptAt = cloneTime(q.NextAttemptAt)
+	c.DeadlineAt = cloneTime(q.DeadlineAt)
+	c.LockedUntil = cloneTime(q.LockedUntil)
+	c.Providers = slices.Clone(q.Providers)
+	if q.Cadastral != nil {
+		n := *q.Cadastral
+		c.Cadastral = &n
+	}
+	return c
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := *t
+	return &v
+}
+
+// now is CURRENT_TIMESTAMP
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
@@ -0,0 +1,162 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+)
+
+type deliveryRecord struct {
+	delivery    models.WebhookDelivery
+	lockedUntil *time.Time
+}
+
+// CreateWebhookDelivery is put a delivery into the outbox
+func (s *Store) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[delivery.ID]; ok {
+		return repository.ErrDuplicate
+	}
+
+	d := cloneDelivery(delivery)
+	d.Secret = ""
+	nextAttemptAt := delivery.CreatedAt
+	d.NextAttemptAt = &nextAttemptAt
+	s.deliveries[delivery.ID] = &deliveryRecord{delivery: d}
+	return nil
+}
+
+// ClaimWebhookDelivery is take the next due delivery together with the
+// secret signing it: the one of its request or else of its owner, nil when
+// nothing is due
+func (s *Store) ClaimWebhookDelivery(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := time.Now()
+	var due *deliveryRecord
+	for _, rec := range s.deliveries {
+		d := &rec.delivery
+		if d.Status != models.DeliveryPending || d.NextAttemptAt.After(t) {
+			continue
+		}
+		if rec.lockedUntil != nil && !rec.lockedUntil.Before(t) {
+			continue
+		}
+		if due == nil || d.NextAttemptAt.Before(*due.delivery.NextAttemptAt) {
+			due = rec
+		}
+	}
+	if due == nil {
+		return nil, nil
+	}
+
+	lockedUntil := t.Add(lease)
+	due.lockedUntil = &lockedUntil
+	due.delivery.Attempts++
+
+	d := cloneDelivery(&due.delivery)
+	if rec, ok := s.queries[d.QueryID]; ok && rec.query.CallbackSecret != "" {
+		d.Secret = rec.query.CallbackSecret
+	} else if user, ok := s.users[d.UserID]; ok {
+		d.Secret = user.WebhookSecret
+	}
+	return &d, nil
+}
+
+// RecordWebhookAttempt is write an attempt to the delivery log and move the
+// delivery to its new state
+func (s *Store) RecordWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[attempt.DeliveryID] = append(s.attempts[attempt.DeliveryID], *attempt)
+
+	rec, ok := s.deliveries[attempt.DeliveryID]
+	if !ok {
+		return nil
+	}
+	d := &rec.delivery
+	d.Status = status
+	d.NextAttemptAt = &nextAttemptAt
+	d.LastError = attempt.Error
+	d.LastStatusCode = attempt.StatusCode
+	if status == models.DeliveryDelivered {
+		d.DeliveredAt = now()
+	}
+	rec.lockedUntil = nil
+	return nil
+}
+
+// GetWebhookDeliveries is return latest deliveries, optionally of one user and status
+func (s *Store) GetWebhookDeliveries(ctx context.Context, userID, status string, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for _, rec := range s.deliveries {
+		d := &rec.delivery
+		if (userID == "" || d.UserID == userID) && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, cloneDelivery(d))
+		}
+	}
+
+	slices.SortFunc(deliveries, func(a, b models.WebhookDelivery) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDelivery is return one delivery, ErrNotFound if there is none
+func (s *Store) GetWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.deliveries[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	d := cloneDelivery(&rec.delivery)
+	return &d, nil
+}
+
+// GetWebhookAttempts is return delivery log of one delivery
+func (s *Store) GetWebhookAttempts(ctx context.Context, deliveryID string) ([]models.WebhookAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.attempts[deliveryID]), nil
+}
+
+// RedeliverWebhook is schedule a failed delivery again with a fresh attempt counter
+func (s *Store) RedeliverWebhook(ctx context.Context, id, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.deliveries[id]
+	if !ok || rec.delivery.Status != models.DeliveryFailed || (userID != "" && rec.delivery.UserID != userID) {
+		return false, nil
+	}
+
+	rec.delivery.Status = models.DeliveryPending
+	rec.delivery.Attempts = 0
+	rec.delivery.NextAttemptAt = now()
+	rec.lockedUntil = nil
+	return true, nil
+}
+
+func cloneDelivery(d *models.WebhookDelivery) models.WebhookDelivery {
+	c := *d
+	c.Payload = slices.Clone(d.Payload)
+	c.NextAttemptAt = cloneTime(d.NextAttemptAt)
+	c.DeliveredAt = cloneTime(d.DeliveredAt)
+	return c
+}
@@ -3,153 +3,414 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"cadastral-service/internal/models"
 )
 
+var (
+	// ErrNotFound is returned when a requested record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when a record with the same unique key exists
+	ErrDuplicate = errors.New("already exists")
+)
+
+// uniqueViolation is Postgres error code of a unique constraint violation
+const uniqueViolation = "23505"
+
+// queryColumns is the column list shared by every select on queries
+const queryColumns = `id, cadastral_number, latitude, longitude, status, result, user_id, created_at, completed_at,
+	attempts, last_error, next_attempt_at, callback_url, batch_id, cached, provider,
+	providers, strategy, disagreement, external_job_id, timeout_ms, retry_on_timeout, deadline_at, locked_until`
+
 type Repository struct {
-	db *sql.DB
+	db *dialectDB
 }
 
 func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: &dialectDB{DB: db, dialect: Postgres}}
+}
+
+// NewSQLiteRepository is Repository over a SQLite database
+func NewSQLiteRepository(db *sql.DB) *Repository {
+	return &Repository{db: &dialectDB{DB: db, dialect: SQLite}}
 }
 
+// insertQuery is shared by single and batch inserts
+const insertQuery = `
+	INSERT INTO queries (id, cadastral_number, latitude, longitude, status, user_id, created_at, callback_url, batch_id,
+		provider, providers, strategy, timeout_ms, retry_on_timeout, callback_secret)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+`
+
 // CreateQuery is create a new request
 func (r *Repository) CreateQuery(ctx context.Context, query *models.Query) error {
-	queryStr := `
-		INSERT INTO queries (id, cadastral_number, latitude, longitude, status, user_id, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`
+	_, err := r.db.ExecContext(ctx, insertQuery, insertQueryArgs(query)...)
+	return err
+}
 
-	_, err := r.db.ExecContext(ctx, queryStr,
+func insertQueryArgs(query *models.Query) []interface{} {
+	return []interface{}{
 		query.ID,
 		query.CadastralNumber,
 		query.Latitude,
 		query.Longitude,
 		query.Status,
-		query.UserID,
+		nullString(query.UserID),
 		query.CreatedAt,
-	)
+		nullString(query.CallbackURL),
+		nullString(query.BatchID),
+		nullString(query.Provider),
+		nullString(strings.Join(query.Providers, ",")),
+		nullString(query.Strategy),
+		nullInt(int(query.Timeout.Milliseconds())),
+		query.RetryOnTimeout,
+		nullString(query.CallbackSecret),
+	}
+}
+
+// UpdateQuery is update a status of request, cached tells that the result
+// was not fetched for this request. Only a request still processing under
+// jobID is updated, empty when it waits for no callback; false tells that
+// it was cancelled or another outcome was stored first.
+func (r *Repository) UpdateQuery(ctx context.Context, id, jobID string, status string, result *bool, cached bool) (bool, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = $1, result = $2, cached = $3, completed_at = CURRENT_TIMESTAMP, locked_until = NULL,
+			external_job_id = NULL, deadline_at = NULL
+		WHERE id = $4 AND status = 'processing' AND COALESCE(external_job_id, '') = $5
+	`
+
+	return r.execAffected(ctx, queryStr, status, result, cached, id, jobID)
+}
+
+// FailQuery is finish a request processing under jobID with an error,
+// false when it was cancelled or another outcome was stored first
+func (r *Repository) FailQuery(ctx context.Context, id, jobID string, status string, lastError string) (bool, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = $1, last_error = $2, completed_at = CURRENT_TIMESTAMP, locked_until = NULL,
+			external_job_id = NULL, deadline_at = NULL
+		WHERE id = $3 AND status = 'processing' AND COALESCE(external_job_id, '') = $4
+	`
+
+	return r.execAffected(ctx, queryStr, status, lastError, id, jobID)
+}
+
+// RetryQuery is put a failed request processing under jobID back to the
+// queue until nextAttemptAt, false when it was cancelled or another outcome
+// was stored first
+func (r *Repository) RetryQuery(ctx context.Context, id, jobID string, lastError string, nextAttemptAt time.Time) (bool, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = 'pending', last_error = $1, next_attempt_at = $2, locked_until = NULL,
+			external_job_id = NULL, deadline_at = NULL
+		WHERE id = $3 AND status = 'processing' AND COALESCE(external_job_id, '') = $4
+	`
+
+	return r.execAffected(ctx, queryStr, lastError, nextAttemptAt, id, jobID)
+}
+
+// CancelQuery is cancel a pending or processing request. Returns false when
+// there is no such request or it is already finished.
+func (r *Repository) CancelQuery(ctx context.Context, id, userID string) (bool, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = 'cancelled', completed_at = CURRENT_TIMESTAMP, locked_until = NULL,
+			external_job_id = NULL, deadline_at = NULL
+		WHERE id = $1 AND status IN ('pending', 'processing')
+	`
+	args := []interface{}{id}
+
+	if userID != "" {
+		queryStr += ` AND user_id = $2`
+		args = append(args, userID)
+	}
+
+	return r.execAffected(ctx, queryStr, args...)
+}
+
+// execAffected is run an update and tell whether it changed any row
+func (r *Repository) execAffected(ctx context.Context, queryStr string, args ...interface{}) (bool, error) {
+	res, err := r.db.ExecContext(ctx, queryStr, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// RequeueQuery is reset a dead-lettered, failed or timed out request to pending with
+// a fresh attempt counter. Returns false when there is no such request or it
+// is in another status.
+func (r *Repository) RequeueQuery(ctx context.Context, id, userID string) (bool, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = 'pending', result = NULL, cached = FALSE, disagreement = FALSE, attempts = 0, next_attempt_at = NULL,
+			completed_at = NULL, external_job_id = NULL, deadline_at = NULL
+		WHERE id = $1 AND status IN ('dead_letter', 'failed', 'timed_out')
+	`
+	args := []interface{}{id}
 
+	if userID != "" {
+		queryStr += ` AND user_id = $2`
+		args = append(args, userID)
+	}
+
+	return r.execAffected(ctx, queryStr, args...)
+}
+
+// ClaimQuery is take the oldest pending request for processing.
+// Requests left in processing by a dead worker become claimable again once
+// their lease expires, so do requests whose callback did not come; for them
+// the claim is not a new attempt and keeps the job, so whichever of the
+// callback and the worker stores its outcome first wins. Returns nil when
+// the queue is empty.
+func (r *Repository) ClaimQuery(ctx context.Context, lease time.Duration) (*models.Query, error) {
+	queryStr := `
+		UPDATE queries
+		SET status = 'processing', locked_until = $1,
+			attempts = attempts + CASE WHEN external_job_id IS NULL THEN 1 ELSE 0 END
+		WHERE id = (
+			SELECT id FROM queries
+			WHERE (status = 'pending' AND (next_attempt_at IS NULL OR next_attempt_at <= CURRENT_TIMESTAMP))
+			   OR (status = 'processing' AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + queryColumns
+
+	query, err := scanQuery(r.db.QueryRowContext(ctx, queryStr, time.Now().Add(lease)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return query, err
+}
+
+// ReleaseQuery is return a claimed request back to the queue, claimable
+// again from nextAttemptAt or at once when it is zero. The interrupted
+// attempt is not counted.
+func (r *Repository) ReleaseQuery(ctx context.Context, id string, nextAttemptAt time.Time) error {
+	queryStr := `
+		UPDATE queries
+		SET status = 'pending', locked_until = NULL, next_attempt_at = $1, attempts = GREATEST(attempts - 1, 0),
+			external_job_id = NULL, deadline_at = NULL
+		WHERE id = $2 AND status = 'processing'
+	`
+
+	_, err := r.db.ExecContext(ctx, queryStr, nullTime(nextAttemptAt), id)
 	return err
 }
 
-// UpdateQuery is update a status of request
-func (r *Repository) UpdateQuery(ctx context.Context, id string, status string, result *bool) error {
+// AwaitCallback is keep a request in processing until the provider calls
+// back about jobID, or until the lease runs out and the request is claimed
+// again. A non-zero deadline is when the request times out.
+func (r *Repository) AwaitCallback(ctx context.Context, id, jobID string, until, deadline time.Time) error {
 	queryStr := `
-		UPDATE queries 
-		SET status = $1, result = $2, completed_at = CURRENT_TIMESTAMP
-		WHERE id = $3
+		UPDATE queries
+		SET external_job_id = $1, locked_until = $2, deadline_at = $3
+		WHERE id = $4 AND status = 'processing'
 	`
 
-	_, err := r.db.ExecContext(ctx, queryStr, status, result, id)
+	_, err := r.db.ExecContext(ctx, queryStr, jobID, until, nullTime(deadline), id)
 	return err
 }
 
-// GetQueries is return list of request
-func (r *Repository) GetQueries(ctx context.Context, userID, page, limit string) ([]models.Query, error) {
-	var queries []models.Query
-	var queryStr string
+// GetQueryByJob is return the request waiting for a callback about a job of
+// a provider, ErrNotFound if there is none
+func (r *Repository) GetQueryByJob(ctx context.Context, provider, jobID string) (*models.Query, error) {
+	queryStr := `SELECT ` + queryColumns + ` FROM queries WHERE provider = $1 AND external_job_id = $2`
+
+	query, err := scanQuery(r.db.QueryRowContext(ctx, queryStr, provider, jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return query, err
+}
+
+// ParkCallback is keep a callback about a job no request waits for yet,
+// a later one about the same job replaces it. Callbacks received before
+// expire are dropped, nobody is going to take them.
+func (r *Repository) ParkCallback(ctx context.Context, cb *models.JobCallback, expire time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM early_callbacks WHERE received_at < $1`, expire); err != nil {
+		return err
+	}
+
+	queryStr := `
+		INSERT INTO early_callbacks (provider, job_id, result, error, body, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider, job_id) DO UPDATE
+		SET result = excluded.result, error = excluded.error, body = excluded.body, received_at = excluded.received_at
+	`
+
+	_, err := r.db.ExecContext(ctx, queryStr, cb.Provider, cb.JobID, cb.Result, nullString(cb.Error), cb.Body, cb.ReceivedAt)
+	return err
+}
+
+// TakeCallback is remove and return the parked callback about a job,
+// ErrNotFound if there is none. Only one caller gets it.
+func (r *Repository) TakeCallback(ctx context.Context, provider, jobID string) (*models.JobCallback, error) {
+	queryStr := `
+		DELETE FROM early_callbacks
+		WHERE provider = $1 AND job_id = $2
+		RETURNING result, error, body, received_at
+	`
+
+	cb := models.JobCallback{Provider: provider, JobID: jobID}
+	var callbackErr sql.NullString
+	err := r.db.QueryRowContext(ctx, queryStr, provider, jobID).Scan(&cb.Result, &callbackErr, &cb.Body, &cb.ReceivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	cb.Error = callbackErr.String
+	return &cb, nil
+}
+
+// CountPendingQueries is return number of requests waiting in the queue,
+// optionally only for one user
+func (r *Repository) CountPendingQueries(ctx context.Context, userID string) (int, error) {
+	queryStr := `SELECT COUNT(*) FROM queries WHERE status = 'pending'`
 	var args []interface{}
 
 	if userID != "" {
-		queryStr = `
-			SELECT id, cadastral_number, latitude, longitude, status, result, user_id, created_at, completed_at
-			FROM queries
-			WHERE user_id = $1
-			ORDER BY created_at DESC
-			LIMIT $2 OFFSET $3
-		`
-		args = []interface{}{userID, limit, (page - 1) * limit}
-	} else {
-		queryStr = `
-			SELECT id, cadastral_number, latitude, longitude, status, result, user_id, created_at, completed_at
-			FROM queries
-			ORDER BY created_at DESC
-			LIMIT $1 OFFSET $2
-		`
-		args = []interface{}{limit, (page - 1) * limit}
+		queryStr += ` AND user_id = $1`
+		args = append(args, userID)
 	}
 
-	rows, err := r.db.QueryContext(ctx, queryStr, args...)
+	var count int
+	err := r.db.QueryRowContext(ctx, queryStr, args...).Scan(&count)
+	return count, err
+}
+
+// GetQueryByID is return one request, ErrNotFound if there is none
+func (r *Repository) GetQueryByID(ctx context.Context, id string) (*models.Query, error) {
+	queryStr := `SELECT ` + queryColumns + ` FROM queries WHERE id = $1`
+
+	query, err := scanQuery(r.db.QueryRowContext(ctx, queryStr, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return query, err
+}
+
+// GetQueries is return list of request
+func (r *Repository) GetQueries(ctx context.Context, filter QueryFilter, page, limit int) ([]models.Query, error) {
+	where, args := filter.where(nil)
+	args = append(args, limit, (page-1)*limit)
+
+	queryStr := `SELECT ` + queryColumns + ` FROM queries` + where + filter.orderBy() + `
+		LIMIT ` + placeholder(len(args)-1) + ` OFFSET ` + placeholder(len(args))
+
+	return r.selectQueries(ctx, queryStr, args...)
+}
+
+// Keyset is position in history ordered by (created_at, id)
+type Keyset struct {
+	CreatedAt time.Time
+	ID        string
+	// Before is the page preceding the position instead of following it
+	Before bool
+}
+
+// GetQueriesPage is return up to limit requests next to the key in the
+// filter order (created_at, id), the first page when key is nil. more tells
+// whether there are requests beyond the page in the same direction.
+func (r *Repository) GetQueriesPage(ctx context.Context, filter QueryFilter, key *Keyset, limit int) (queries []models.Query, more bool, err error) {
+	filter.Sort = "created_at"
+	where, args := filter.where(nil)
+
+	// walking backwards reads in reverse order and flips the page afterwards
+	backwards := key != nil && key.Before
+	order := filter
+	if backwards {
+		order.Desc = !order.Desc
+	}
+
+	if key != nil {
+		op := ">"
+		if order.Desc {
+			op = "<"
+		}
+		args = append(args, key.CreatedAt, key.ID)
+		condition := "(created_at, id) " + op + " (" + placeholder(len(args)-1) + ", " + placeholder(len(args)) + ")"
+		if where == "" {
+			where = " WHERE " + condition
+		} else {
+			where += " AND " + condition
+		}
+	}
+
+	args = append(args, limit+1)
+	queryStr := `SELECT ` + queryColumns + ` FROM queries` + where + order.orderBy() + `
+		LIMIT ` + placeholder(len(args))
+
+	queries, err = r.selectQueries(ctx, queryStr, args...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var q models.Query
-		err := rows.Scan(
-			&q.ID,
-			&q.CadastralNumber,
-			&q.Latitude,
-			&q.Longitude,
-			&q.Status,
-			&q.Result,
-			&q.UserID,
-			&q.CreatedAt,
-			&q.CompletedAt,
-		)
-		if err != nil {
-			return nil, err
+	if len(queries) > limit {
+		queries, more = queries[:limit], true
+	}
+	if backwards {
+		for i, j := 0, len(queries)-1; i < j; i, j = i+1, j-1 {
+			queries[i], queries[j] = queries[j], queries[i]
 		}
-		queries = append(queries, q)
 	}
+	return queries, more, nil
+}
 
-	return queries, nil
+// CountQueries is number of requests matching the filter
+func (r *Repository) CountQueries(ctx context.Context, filter QueryFilter) (int, error) {
+	where, args := filter.where(nil)
+
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM queries`+where, args...).Scan(&count)
+	return count, err
 }
 
 // GetQueriesByCadastral is return request by cadastral number
 func (r *Repository) GetQueriesByCadastral(ctx context.Context, cadastralNumber, userID string) ([]models.Query, error) {
-	var queries []models.Query
-	var queryStr string
-	var args []interface{}
+	where, args := QueryFilter{UserID: userID, CadastralNumber: cadastralNumber}.where(nil)
 
-	if userID != "" {
-		queryStr = `
-			SELECT id, cadastral_number, latitude, longitude, status, result, user_id, created_at, completed_at
-			 FROM queries
-			WHERE cadastral_number = $1 AND user_id = $2
-			ORDER BY created_at DESC
-		`
-		args = []interface{}{cadastralNumber, userID}
-	} else {
-		queryStr = `
-			SELECT id, cadastral_number, latitude, longitude, status, result, user_id, created_at, completed_at
-			FROM queries
-			WHERE cadastral_number = $1
-			ORDER BY created_at DESC
-		`
-		args = []interface{}{cadastralNumber}
-	}
+	queryStr := `SELECT ` + queryColumns + ` FROM queries` + where + `
+		ORDER BY created_at DESC`
+
+	return r.selectQueries(ctx, queryStr, args...)
+}
+
+// StreamQueries is call fn for every request matching the filter in its
+// order, without loading the whole result into memory
+func (r *Repository) StreamQueries(ctx context.Context, filter QueryFilter, fn func(*models.Query) error) error {
+	where, args := filter.where(nil)
+
+	queryStr := `SELECT ` + queryColumns + ` FROM queries` + where + filter.orderBy()
 
 	rows, err := r.db.QueryContext(ctx, queryStr, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var q models.Query
-		err := rows.Scan(
-			&q.ID,
-			&q.CadastralNumber,
-			&q.Latitude,
-			&q.Longitude,
-			&q.Status,
-			&q.Result,
-			&q.UserID,
-			&q.CreatedAt,
-			&q.CompletedAt,
-		)
+		query, err := scanQuery(rows)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(query); err != nil {
+			return err
 		}
-		queries = append(queries, q)
 	}
 
-	return queries, nil
+	return rows.Err()
 }
 
 // CreateUser is create a new user
@@ -166,28 +427,173 @@ func (r *Repository) CreateUser(ctx context.Context, user *models.User) error {
 		user.CreatedAt,
 	)
 
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
 	return err
 }
 
-// GetUserByUsername is return user by name
+// GetUserByUsername is return user by name, ErrNotFound if there is none
 func (r *Repository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	queryStr := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, queryStr, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// GetUserByID is return user by ID, ErrNotFound if there is none
+func (r *Repository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	queryStr := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, queryStr, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// UpdateUserWebhook is set callback URL and signing secret of a user,
+// empty url removes the webhook
+func (r *Repository) UpdateUserWebhook(ctx context.Context, userID, url, secret string) error {
 	queryStr := `
-		SELECT id, username, password_hash, created_at
-		FROM users
-		WHERE username = $1
+		UPDATE users
+		SET webhook_url = $1, webhook_secret = $2
+		WHERE id = $3
 	`
 
+	_, err := r.db.ExecContext(ctx, queryStr, nullString(url), nullString(secret), userID)
+	return err
+}
+
+// selectQueries is run a select on queries and scan every row
+func (r *Repository) selectQueries(ctx context.Context, queryStr string, args ...interface{}) ([]models.Query, error) {
+	rows, err := r.db.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queries []models.Query
+	for rows.Next() {
+		q, err := scanQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, *q)
+	}
+
+	return queries, rows.Err()
+}
+
+const userColumns = `id, username, password_hash, webhook_url, webhook_secret, created_at`
+
+// scanUser is read userColumns from a row
+func scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
-	err := r.db.QueryRowContext(ctx, queryStr, username).Scan(
+	var webhookURL, webhookSecret sql.NullString
+
+	err := row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.PasswordHash,
+		&webhookURL,
+		&webhookSecret,
 		&user.CreatedAt,
 	)
-
 	if err != nil {
 		return nil, err
 	}
 
+	user.WebhookURL = webhookURL.String
+	user.WebhookSecret = webhookSecret.String
 	return &user, nil
 }
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanQuery is read queryColumns from a row
+func scanQuery(row rowScanner) (*models.Query, error) {
+	var q models.Query
+	var userID, lastError, callbackURL, batchID, provider, providers, strategy, jobID sql.NullString
+	var completedAt, nextAttemptAt, deadlineAt, lockedUntil sql.NullTime
+	var timeoutMs sql.NullInt64
+
+	err := row.Scan(
+		&q.ID,
+		&q.CadastralNumber,
+		&q.Latitude,
+		&q.Longitude,
+		&q.Status,
+		&q.Result,
+		&userID,
+		&q.CreatedAt,
+		&completedAt,
+		&q.Attempts,
+		&lastError,
+		&nextAttemptAt,
+		&callbackURL,
+		&batchID,
+		&q.Cached,
+		&provider,
+		&providers,
+		&strategy,
+		&q.Disagreement,
+		&jobID,
+		&timeoutMs,
+		&q.RetryOnTimeout,
+		&deadlineAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	q.ParseCadastral()
+	q.UserID = userID.String
+	q.LastError = lastError.String
+	q.CallbackURL = callbackURL.String
+	q.BatchID = batchID.String
+	q.Provider = provider.String
+	if providers.Valid {
+		q.Providers = strings.Split(providers.String, ",")
+	}
+	q.Strategy = strategy.String
+	q.ExternalJobID = jobID.String
+	q.Timeout = time.Duration(timeoutMs.Int64) * time.Millisecond
+	if completedAt.Valid {
+		q.CompletedAt = &completedAt.Time
+	}
+	if nextAttemptAt.Valid {
+		q.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deadlineAt.Valid {
+		q.DeadlineAt = &deadlineAt.Time
+	}
+	if lockedUntil.Valid {
+		q.LockedUntil = &lockedUntil.Time
+	}
+
+	return &q, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// placeholder is return positional parameter $n
+func placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"cadastral-service/internal/models"
+)
+
+// QueryStore is storage of requests, it doubles as the processing queue
+type QueryStore interface {
+	CreateQuery(ctx context.Context, query *models.Query) error
+	UpdateQuery(ctx context.Context, id, jobID string, status string, result *bool, cached bool) (bool, error)
+	FailQuery(ctx context.Context, id, jobID string, status string, lastError string) (bool, error)
+	RetryQuery(ctx context.Context, id, jobID string, lastError string, nextAttemptAt time.Time) (bool, error)
+	RequeueQuery(ctx context.Context, id, userID string) (bool, error)
+	CancelQuery(ctx context.Context, id, userID string) (bool, error)
+	ClaimQuery(ctx context.Context, lease time.Duration) (*models.Query, error)
+	ReleaseQuery(ctx context.Context, id string, nextAttemptAt time.Time) error
+	AwaitCallback(ctx context.Context, id, jobID string, until, deadline time.Time) error
+	GetQueryByJob(ctx context.Context, provider, jobID string) (*models.Query, error)
+	ParkCallback(ctx context.Context, cb *models.JobCallback, expire time.Time) error
+	TakeCallback(ctx context.Context, provider, jobID string) (*models.JobCallback, error)
+	CountPendingQueries(ctx context.Context, userID string) (int, error)
+	GetQueryByID(ctx context.Context, id string) (*models.Query, error)
+	GetQueries(ctx context.Context, filter QueryFilter, page, limit int) ([]models.Query, error)
+	GetQueriesPage(ctx context.Context, filter QueryFilter, key *Keyset, limit int) ([]models.Query, bool, error)
+	CountQueries(ctx context.Context, filter QueryFilter) (int, error)
+	GetQueriesByCadastral(ctx context.Context, cadastralNumber, userID string) ([]models.Query, error)
+	StreamQueries(ctx context.Context, filter QueryFilter, fn func(*models.Query) error) error
+}
+
+// AttemptStore is the log of external server calls kept in query_history
+type AttemptStore interface {
+	RecordQueryAttempt(ctx context.Context, attempt *models.QueryAttempt) error
+	GetQueryAttempts(ctx context.Context, queryID string) ([]models.QueryAttempt, error)
+}
+
+// AnswerStore is storage of answers of the providers of a fanned out request
+type AnswerStore interface {
+	SaveProviderAnswers(ctx context.Context, queryID string, answers []models.ProviderAnswer, disagreement bool) error
+	GetProviderAnswers(ctx context.Context, queryID string) ([]models.ProviderAnswer, error)
+}
+
+// UserStore is storage of users and their webhook settings
+type UserStore interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	UpdateUserWebhook(ctx context.Context, userID, url, secret string) error
+}
+
+// WebhookStore is the outbox of webhook deliveries and their log
+type WebhookStore interface {
+	CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	ClaimWebhookDelivery(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt, status string, nextAttemptAt time.Time) error
+	GetWebhookDeliveries(ctx context.Context, userID, status string, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error)
+	GetWebhookAttempts(ctx context.Context, deliveryID string) ([]models.WebhookAttempt, error)
+	RedeliverWebhook(ctx context.Context, id, userID string) (bool, error)
+}
+
+// BatchStore is storage of batches and import reports
+type BatchStore interface {
+	CreateBatch(ctx context.Context, batch *models.Batch, queries []*models.Query) error
+	CreateImport(ctx context.Context, batch *models.Batch, queries []*models.Query, report []models.ImportRow) error
+	GetBatch(ctx context.Context, id string) (*models.Batch, error)
+	CountBatchStatuses(ctx context.Context, batchID string) (map[string]int, error)
+	GetImportRows(ctx context.Context, batchID string) ([]models.ImportRow, error)
+}
+
+// Store is everything the service and the API keep, implemented by
+// Repository over Postgres and by memory.Store
+type Store interface {
+	QueryStore
+	AttemptStore
+	AnswerStore
+	UserStore
+	WebhookStore
+	BatchStore
+}
+
+var _ Store = (*Repository)(nil)
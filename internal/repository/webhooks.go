@@ -0,0 +1,298 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"cadastral-service/internal/models"
+)
+
+const deliveryColumns = `d.id, d.query_id, d.user_id, d.url, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_error, d.last_status_code, d.created_at, d.delivered_at`
+
+// CreateWebhookDelivery is put a delivery into the outbox
+func (r *Repository) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	queryStr := `
+		INSERT INTO webhook_deliveries (id, query_id, user_id, url, payload, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := r.db.ExecContext(ctx, queryStr,
+		delivery.ID,
+		delivery.QueryID,
+		nullString(delivery.UserID),
+		delivery.URL,
+		string(delivery.Payload),
+		delivery.Status,
+		delivery.CreatedAt,
+		delivery.CreatedAt,
+	)
+
+	return err
+}
+
+// ClaimWebhookDelivery is take the next due delivery together with the secret
+// signing it: the one of its request, or else of its owner. Returns nil when
+// nothing is due.
+func (r *Repository) ClaimWebhookDelivery(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	if r.db.dialect == SQLite {
+		return r.claimWebhookDeliverySQLite(ctx, lease)
+	}
+
+	queryStr := `
+		UPDATE webhook_deliveries d
+		SET locked_until = $1, attempts = d.attempts + 1
+		FROM (
+			SELECT wd.id, COALESCE(q.callback_secret, u.webhook_secret) AS secret
+			FROM webhook_deliveries wd
+			LEFT JOIN queries q ON q.id = wd.query_id
+			LEFT JOIN users u ON u.id = wd.user_id
+			WHERE wd.status = 'pending'
+			  AND wd.next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (wd.locked_until IS NULL OR wd.locked_until < CURRENT_TIMESTAMP)
+			ORDER BY wd.next_attempt_at
+			LIMIT 1
+			FOR UPDATE OF wd SKIP LOCKED
+		) due
+		WHERE d.id = due.id
+		RETURNING ` + deliveryColumns + `, due.secret`
+
+	var secret sql.NullString
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, queryStr, time.Now().Add(lease)), &secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Secret = secret.String
+	return delivery, nil
+}
+
+// claimWebhookDeliverySQLite is ClaimWebhookDelivery for SQLite, where
+// RETURNING of UPDATE FROM can not read the joined table
+func (r *Repository) claimWebhookDeliverySQLite(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	queryStr := `
+		UPDATE webhook_deliveries
+		SET locked_until = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending'
+			  AND next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+			ORDER BY next_attempt_at
+			LIMIT 1
+		)
+		RETURNING ` + strings.ReplaceAll(deliveryColumns, "d.", "")
+
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, queryStr, time.Now().Add(lease)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var secret sql.NullString
+	err = r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(
+			(SELECT callback_secret FROM queries WHERE id = $1),
+			(SELECT webhook_secret FROM users WHERE id = $2))`,
+		delivery.QueryID, delivery.UserID).Scan(&secret)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Secret = secret.String
+	return delivery, nil
+}
+
+// RecordWebhookAttempt is write an attempt to the delivery log and move the
+// delivery to its new state; nextAttemptAt is used only for pending
+func (r *Repository) RecordWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`,
+		attempt.DeliveryID,
+		attempt.Attempt,
+		nullInt(attempt.StatusCode),
+		nullString(attempt.Error),
+		attempt.DurationMs,
+		attempt.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1,
+			next_attempt_at = $2,
+			locked_until = NULL,
+			last_error = $3,
+			last_status_code = $4,
+			delivered_at = CASE WHEN $1 = 'delivered' THEN CURRENT_TIMESTAMP ELSE delivered_at END
+		WHERE id = $5
+	`,
+		status,
+		nextAttemptAt,
+		nullString(attempt.Error),
+		nullInt(attempt.StatusCode),
+		attempt.DeliveryID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWebhookDeliveries is return latest deliveries, optionally of one user and status
+func (r *Repository) GetWebhookDeliveries(ctx context.Context, userID, status string, limit int) ([]models.WebhookDelivery, error) {
+	queryStr := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries d WHERE 1 = 1`
+	var args []interface{}
+
+	if userID != "" {
+		args = append(args, userID)
+		queryStr += ` AND d.user_id = $1`
+	}
+	if status != "" {
+		args = append(args, status)
+		queryStr += ` AND d.status = ` + placeholder(len(args))
+	}
+	args = append(args, limit)
+	queryStr += ` ORDER BY d.created_at DESC LIMIT ` + placeholder(len(args))
+
+	rows, err := r.db.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetWebhookDelivery is return one delivery, ErrNotFound if there is none
+func (r *Repository) GetWebhookDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	queryStr := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries d WHERE d.id = $1`
+
+	delivery, err := scanDelivery(r.db.QueryRowContext(ctx, queryStr, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return delivery, err
+}
+
+// GetWebhookAttempts is return delivery log of one delivery
+func (r *Repository) GetWebhookAttempts(ctx context.Context, deliveryID string) ([]models.WebhookAttempt, error) {
+	queryStr := `
+		SELECT delivery_id, attempt, status_code, error, duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.QueryContext(ctx, queryStr, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.WebhookAttempt
+	for rows.Next() {
+		var a models.WebhookAttempt
+		var statusCode sql.NullInt64
+		var errMsg sql.NullString
+		if err := rows.Scan(&a.DeliveryID, &a.Attempt, &statusCode, &errMsg, &a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.StatusCode = int(statusCode.Int64)
+		a.Error = errMsg.String
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// RedeliverWebhook is schedule a failed delivery again with a fresh attempt
+// counter. Returns false when there is no such failed delivery.
+func (r *Repository) RedeliverWebhook(ctx context.Context, id, userID string) (bool, error) {
+	queryStr := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, locked_until = NULL
+		WHERE id = $1 AND status = 'failed'
+	`
+	args := []interface{}{id}
+
+	if userID != "" {
+		queryStr += ` AND user_id = $2`
+		args = append(args, userID)
+	}
+
+	res, err := r.db.ExecContext(ctx, queryStr, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// scanDelivery is read deliveryColumns and any extra columns from a row
+func scanDelivery(row rowScanner, extra ...interface{}) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var userID, lastError sql.NullString
+	var lastStatusCode sql.NullInt64
+	var nextAttemptAt, deliveredAt sql.NullTime
+	var payload []byte
+
+	dest := []interface{}{
+		&d.ID,
+		&d.QueryID,
+		&userID,
+		&d.URL,
+		&payload,
+		&d.Status,
+		&d.Attempts,
+		&nextAttemptAt,
+		&lastError,
+		&lastStatusCode,
+		&d.CreatedAt,
+		&deliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	d.UserID = userID.String
+	d.Payload = payload
+	d.LastError = lastError.String
+	d.LastStatusCode = int(lastStatusCode.Int64)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+
+	return &d, nil
+}
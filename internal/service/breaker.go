@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/metrics"
+)
+
+// states of a circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitOpenError is returned instead of calling a provider whose breaker
+// is open, requests are held until RetryAt without spending an attempt
+type CircuitOpenError struct {
+	Provider string
+	RetryAt  time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of provider %s is open", e.Provider)
+}
+
+// BreakerStatus is the state of the circuit breaker of one provider
+type BreakerStatus struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	// Failures is the number of consecutive failed calls
+	Failures int `json:"failures"`
+	// Opens counts how many times the breaker opened
+	Opens    int        `json:"opens"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"`
+}
+
+// breaker stops calls to a provider after consecutive failures, lets a few
+// probes through once it cooled down and closes again when they succeed
+type breaker struct {
+	provider string
+	cfg      config.BreakerConfig
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	opens    int
+	openedAt time.Time
+	probes   int
+	// generation changes with the state, outcomes of calls allowed in an
+	// earlier one are stale and ignored
+	generation uint64
+}
+
+func newBreaker(provider string, cfg config.BreakerConfig) *breaker {
+	if cfg.HalfOpenRequests < 1 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &breaker{provider: provider, cfg: cfg, state: BreakerClosed}
+}
+
+// allow is tell whether a call may be made now, a call that is allowed must
+// be followed by done with the returned generation
+func (b *breaker) allow() (uint64, error) {
+	if b.cfg.FailureThreshold <= 0 {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.cfg.OpenDuration)
+		if now.Before(retryAt) {
+			return 0, &CircuitOpenError{Provider: b.provider, RetryAt: retryAt}
+		}
+		b.setState(BreakerHalfOpen)
+		b.probes = 0
+		log.Printf("Circuit breaker of provider %s is half-open", b.provider)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			// probes are in flight, try again after them
+			return 0, &CircuitOpenError{Provider: b.provider, RetryAt: now}
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// done is record the outcome of a call allowed in generation. failed is
+// false for calls that tell nothing about the provider health, like
+// cancelled ones. A call that outlived the state it was allowed in changes
+// nothing: only probes close a half-open breaker.
+func (b *breaker) done(generation uint64, succeeded, failed bool) {
+	if b.cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	halfOpen := b.state == BreakerHalfOpen
+	if halfOpen && b.probes > 0 {
+		b.probes--
+	}
+
+	switch {
+	case succeeded:
+		if halfOpen {
+			log.Printf("Circuit breaker of provider %s closed", b.provider)
+			b.setState(BreakerClosed)
+		}
+		b.failures = 0
+	case failed:
+		b.failures++
+		if halfOpen || b.failures >= b.cfg.FailureThreshold {
+			b.setState(BreakerOpen)
+			b.openedAt = time.Now()
+			b.opens++
+			log.Printf("Circuit breaker of provider %s opened after %d failures", b.provider, b.failures)
+		}
+	}
+}
+
+// setState is move the breaker to state, calls in flight become stale
+func (b *breaker) setState(state string) {
+	b.state = state
+	b.generation++
+}
+
+// status is a snapshot of the breaker
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		Provider: b.provider,
+		State:    b.state,
+		Failures: b.failures,
+		Opens:    b.opens,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.cfg.OpenDuration)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// breaker is the circuit breaker of a provider
+func (s *Service) breaker(provider string) *breaker {
+	s.breakersMu.Lock()
+	defer s.breakersMu.Unlock()
+
+	b, ok := s.breakers[provider]
+	if !ok {
+		b = newBreaker(provider, s.cfg.Breaker)
+		s.breakers[provider] = b
+	}
+	return b
+}
+
+// Breakers is the state of the circuit breaker of every provider
+func (s *Service) Breakers() []BreakerStatus {
+	names := s.providers.Names()
+	sort.Strings(names)
+
+	statuses := make([]BreakerStatus, len(names))
+	for i, name := range names {
+		statuses[i] = s.breaker(name).status()
+	}
+	return statuses
+}
+
+// breakerMetrics is the state of the circuit breaker of every provider
+func (s *Service) breakerMetrics() []metrics.Metric {
+	state := metrics.Metric{
+		Name: "cadastral_provider_breaker_state",
+		Help: "Circuit breaker state of a provider: 0 closed, 1 half-open, 2 open.",
+		Type: metrics.Gauge,
+	}
+	failures := metrics.Metric{
+		Name: "cadastral_provider_breaker_failures",
+		Help: "Consecutive failed calls to a provider.",
+		Type: metrics.Gauge,
+	}
+	opens := metrics.Metric{
+		Name: "cadastral_provider_breaker_opens_total",
+		Help: "Times the circuit breaker of a provider opened.",
+		Type: metrics.Counter,
+	}
+
+	for _, b := range s.Breakers() {
+		labels := map[string]string{"provider": b.Provider}
+		value := 0.0
+		switch b.State {
+		case BreakerHalfOpen:
+			value = 1
+		case BreakerOpen:
+			value = 2
+		}
+		state.Samples = append(state.Samples, metrics.Sample{Labels: labels, Value: value})
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: labels, Value: float64(b.Failures)})
+		opens.Samples = append(opens.Samples, metrics.Sample{Labels: labels, Value: float64(b.Opens)})
+	}
+
+	return []metrics.Metric{state, failures, opens}
+}
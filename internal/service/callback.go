@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cadastral-service/internal/cache"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/repository"
+	"cadastral-service/pkg/idgen"
+)
+
+var (
+	// ErrCallbackTimeout is the failure of a job whose callback did not come in time
+	ErrCallbackTimeout = errors.New("provider did not call back in time")
+	// ErrNotCallbackProvider is returned by HandleCallback for names of
+	// providers that do not answer by callback
+	ErrNotCallbackProvider = errors.New("no provider answers by callback under this name")
+	// ErrInvalidCallback is returned by HandleCallback for signed callbacks it cannot read
+	ErrInvalidCallback = errors.New("invalid callback")
+
+	// errAwaitingCallback tells that a job was submitted and the request
+	// waits for its callback
+	errAwaitingCallback = errors.New("awaiting callback")
+)
+
+// AnswersByCallback is tell whether a provider delivers its verdicts by callback
+func (s *Service) AnswersByCallback(name string) bool {
+	p, ok := s.providers.Get(name)
+	if !ok {
+		return false
+	}
+	_, ok = p.(provider.CallbackProvider)
+	return ok
+}
+
+// submitJob is submit a request to a provider answering by callback and
+// keep it in processing until the callback comes
+func (s *Service) submitJob(ctx context.Context, query *models.Query, p provider.CallbackProvider) (bool, bool, error) {
+	if len(query.Providers) > 0 {
+		return false, false, fmt.Errorf("provider %s answers by callback, it cannot be asked together with others", p.Name())
+	}
+
+	resp, err := s.call(ctx, query, p)
+	if err != nil {
+		return false, false, err
+	}
+
+	// the provider knew the verdict at once
+	if resp.JobID == "" {
+		s.cacheResult(ctx, cache.Key(p.Name(), query.CadastralNumber, query.Latitude, query.Longitude), resp.Result)
+		return resp.Result, false, nil
+	}
+
+	// the callback is waited for the callback timeout of the provider, the
+	// default deadline is meant for calls and cut to the lease. A request that
+	// asked for its own deadline waits no longer than it; the deadline is kept
+	// with the job so a late callback or a reclaim sees it passed.
+	until := time.Now().Add(p.CallbackTimeout())
+	var deadline time.Time
+	if query.Timeout > 0 {
+		deadline, _ = ctx.Deadline()
+	}
+	if !deadline.IsZero() && deadline.Before(until) {
+		until = deadline
+	}
+
+	// without the job id stored the callback is not matched, the request is
+	// submitted again when its lease runs out
+	if err := s.repo.AwaitCallback(ctx, query.ID, resp.JobID, until, deadline); err != nil {
+		log.Printf("Failed to store job %s of query %s: %v", resp.JobID, query.ID, err)
+	} else {
+		// the outcome is stored only while the request still waits for this job
+		query.ExternalJobID = resp.JobID
+	}
+
+	// the provider may have called back before the job was stored
+	cb, err := s.repo.TakeCallback(ctx, p.Name(), resp.JobID)
+	if err == nil {
+		log.Printf("Query %s got callback of job %s from provider %s before it was stored", query.ID, resp.JobID, p.Name())
+		result, err := s.callbackOutcome(ctx, query, cb)
+		return result, false, err
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to take early callback of job %s: %v", resp.JobID, err)
+	}
+
+	log.Printf("Query %s waits for callback of job %s from provider %s", query.ID, resp.JobID, p.Name())
+	return false, false, errAwaitingCallback
+}
+
+// HandleCallback is apply the verdict a provider delivered about a job to
+// the request waiting for it. A callback about a job no request waits for
+// yet is kept for the request that submitted it. Returns
+// provider.ErrInvalidSignature for callbacks not signed with the shared
+// secret and ErrInvalidCallback for unreadable ones.
+func (s *Service) HandleCallback(ctx context.Context, name string, header http.Header, body []byte) error {
+	p, ok := s.providers.Get(name)
+	if !ok || name == "" {
+		return ErrNotCallbackProvider
+	}
+	cp, ok := p.(provider.CallbackProvider)
+	if !ok {
+		return ErrNotCallbackProvider
+	}
+
+	parsed, err := cp.ParseCallback(header, body)
+	if errors.Is(err, provider.ErrInvalidSignature) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	// the verdict is applied even when the provider hangs up on us
+	ctx = context.WithoutCancel(ctx)
+
+	cb := &models.JobCallback{
+		Provider:   cp.Name(),
+		JobID:      parsed.JobID,
+		Result:     parsed.Result,
+		Error:      parsed.Error,
+		Body:       body,
+		ReceivedAt: time.Now(),
+	}
+
+	query, err := s.repo.GetQueryByJob(ctx, cb.Provider, cb.JobID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return s.parkCallback(ctx, cp, cb)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.applyCallback(ctx, query, cb)
+	return nil
+}
+
+// parkCallback is keep a callback that came before its job was stored.
+// The job may be stored meanwhile, then whoever takes the callback first
+// applies it: the request after storing the job or this callback.
+func (s *Service) parkCallback(ctx context.Context, p provider.CallbackProvider, cb *models.JobCallback) error {
+	// no request waits for a job longer than the callback timeout
+	if err := s.repo.ParkCallback(ctx, cb, time.Now().Add(-p.CallbackTimeout())); err != nil {
+		return err
+	}
+
+	query, err := s.repo.GetQueryByJob(ctx, cb.Provider, cb.JobID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cb, err = s.repo.TakeCallback(ctx, cb.Provider, cb.JobID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.applyCallback(ctx, query, cb)
+	return nil
+}
+
+// applyCallback is move a request waiting for a callback on by its verdict
+func (s *Service) applyCallback(ctx context.Context, query *models.Query, cb *models.JobCallback) {
+	result, err := s.callbackOutcome(ctx, query, cb)
+	if pastDeadline(query) {
+		s.handleTimeout(ctx, query)
+		return
+	}
+	s.apply(ctx, query, result, false, err)
+}
+
+// callbackOutcome is log a callback as an attempt of the request and
+// return the verdict it carries
+func (s *Service) callbackOutcome(ctx context.Context, query *models.Query, cb *models.JobCallback) (bool, error) {
+	var callErr error
+	if cb.Error != "" {
+		callErr = fmt.Errorf("provider %s failed job %s: %s", cb.Provider, cb.JobID, cb.Error)
+	} else {
+		s.cacheResult(ctx, cache.Key(cb.Provider, query.CadastralNumber, query.Latitude, query.Longitude), cb.Result)
+	}
+
+	request, _ := json.Marshal(map[string]string{"job_id": cb.JobID})
+	s.recordAttempt(ctx, &models.QueryAttempt{
+		ID:              idgen.New(),
+		QueryID:         query.ID,
+		Provider:        cb.Provider,
+		CadastralNumber: query.CadastralNumber,
+		Attempt:         query.Attempts,
+		Request:         request,
+		Response:        rawResponse(cb.Body),
+		CreatedAt:       cb.ReceivedAt,
+	}, callErr)
+
+	return cb.Result, callErr
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+)
+
+// ErrQueryCancelled is the cause of the context of a request cancelled while it was processed
+var ErrQueryCancelled = errors.New("query cancelled")
+
+// Cancel is cancel a pending or processing request and abort its call to
+// the provider. Returns false when there is no such request or it is
+// already finished.
+func (s *Service) Cancel(ctx context.Context, id, userID string) (bool, error) {
+	ok, err := s.repo.CancelQuery(ctx, id, userID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	s.runningMu.Lock()
+	if cancel, ok := s.running[id]; ok {
+		cancel(ErrQueryCancelled)
+	}
+	s.runningMu.Unlock()
+
+	s.events.Publish(events.Event{QueryID: id, Status: models.StatusCancelled})
+	s.enqueueWebhook(ctx, id)
+	return true, nil
+}
+
+// track is the context of a request being processed, Cancel cancels it.
+// done must be called when the processing is over.
+func (s *Service) track(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	s.runningMu.Lock()
+	s.running[id] = cancel
+	s.runningMu.Unlock()
+
+	return ctx, func() {
+		s.runningMu.Lock()
+		delete(s.running, id)
+		s.runningMu.Unlock()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cadastral-service/internal/models"
+)
+
+// ErrQueryTimedOut is the cause of the context of a request that ran out of its deadline
+var ErrQueryTimedOut = errors.New("query timed out")
+
+// maxLeaseMargin is the most of the lease kept for storing the outcome of a
+// request after its deadline
+const maxLeaseMargin = 2 * time.Second
+
+// timeout is the processing deadline of a request: the one it asked for or
+// the default, cut to the lease so no other worker claims it meanwhile
+func (s *Service) timeout(query *models.Query) time.Duration {
+	timeout := query.Timeout
+	if timeout <= 0 {
+		timeout = s.cfg.Queue.Timeout
+	}
+	if lease := s.cfg.Queue.LeaseDuration; lease > 0 && (timeout <= 0 || timeout > lease) {
+		timeout = lease
+	}
+	return timeout
+}
+
+// withDeadline is ctx cancelled with ErrQueryTimedOut once the deadline of
+// a request passed, it has no deadline when there is no limit. The deadline
+// comes a margin before the lease of the claim runs out, however long the
+// claim took, so the outcome is stored before another worker may claim it.
+func (s *Service) withDeadline(ctx context.Context, query *models.Query) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	if timeout := s.timeout(query); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if query.LockedUntil != nil {
+		margin := min(s.cfg.Queue.LeaseDuration/10, maxLeaseMargin)
+		if leaseEnd := query.LockedUntil.Add(-margin); deadline.IsZero() || leaseEnd.Before(deadline) {
+			deadline = leaseEnd
+		}
+	}
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadlineCause(ctx, deadline, ErrQueryTimedOut)
+}
+
+// timedOut is tell whether ctx ended because the deadline of its request passed
+func timedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrQueryTimedOut)
+}
+
+// pastDeadline is tell whether a request waiting for a callback ran out of time
+func pastDeadline(query *models.Query) bool {
+	return query.DeadlineAt != nil && !time.Now().Before(*query.DeadlineAt)
+}
+
+// handleTimeout is retry a request that ran out of time when it asked for
+// it and attempts remain, otherwise leave it timed out
+func (s *Service) handleTimeout(ctx context.Context, query *models.Query) {
+	timeoutErr := fmt.Errorf("%w after %s", ErrQueryTimedOut, s.timeout(query))
+
+	if !query.RetryOnTimeout || query.Attempts >= s.cfg.Retry.MaxAttempts {
+		log.Printf("Query %s timed out after %d attempts", query.ID, query.Attempts)
+		s.finish(ctx, query, models.StatusTimedOut, nil, timeoutErr)
+		return
+	}
+
+	s.retry(ctx, query, timeoutErr)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+)
+
+// fanOut is ask every provider of a request at once and combine their
+// answers by its strategy. Answers are stored with the disagreement flag
+// whatever the outcome, so a failed consensus can be inspected.
+func (s *Service) fanOut(ctx context.Context, query *models.Query) (bool, bool, error) {
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		answer models.ProviderAnswer
+		err    error
+	}
+	outcomes := make(chan outcome, len(query.Providers))
+
+	for _, name := range query.Providers {
+		go func(name string) {
+			started := time.Now()
+			result, cached, err := s.ask(callCtx, query, name)
+
+			answer := models.ProviderAnswer{
+				QueryID:   query.ID,
+				Provider:  name,
+				Cached:    cached,
+				LatencyMs: time.Since(started).Milliseconds(),
+				CreatedAt: started,
+			}
+			if err == nil {
+				answer.Result = &result
+			}
+			outcomes <- outcome{answer: answer, err: err}
+		}(name)
+	}
+
+	answers := make([]models.ProviderAnswer, 0, len(query.Providers))
+	combined := make([]provider.Answer, 0, len(query.Providers))
+	decided := false
+	for range query.Providers {
+		o := <-outcomes
+
+		if o.err != nil {
+			o.answer.Error = o.err.Error()
+			if decided && errors.Is(o.err, context.Canceled) {
+				o.answer.Error = "skipped, the first answer decided"
+			}
+		}
+		answers = append(answers, o.answer)
+
+		if !decided {
+			a := provider.Answer{Provider: o.answer.Provider, Err: o.err}
+			if o.answer.Result != nil {
+				a.Result = *o.answer.Result
+			}
+			combined = append(combined, a)
+		}
+
+		// the other calls are not needed anymore
+		if query.Strategy == provider.StrategyFirstWins && o.err == nil && !decided {
+			decided = true
+			cancel()
+		}
+	}
+
+	// worker is stopping or the request is cancelled, nothing is decided;
+	// past the deadline the answers that came are still combined
+	if ctx.Err() != nil && !timedOut(ctx) {
+		return false, false, ctx.Err()
+	}
+
+	result, disagreement, err := provider.Aggregate(query.Strategy, len(query.Providers), combined)
+	if saveErr := s.repo.SaveProviderAnswers(context.WithoutCancel(ctx), query.ID, answers, disagreement); saveErr != nil {
+		log.Printf("Failed to save provider answers of query %s: %v", query.ID, saveErr)
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cached := true
+	for _, a := range answers {
+		if a.Result != nil && !a.Cached {
+			cached = false
+		}
+	}
+	return result, cached, nil
+}
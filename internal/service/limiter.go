@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/metrics"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedError is returned instead of calling a provider when the turn
+// of the call comes after the deadline of its request, the request is held
+// until RetryAt without spending an attempt
+type RateLimitedError struct {
+	Provider string
+	RetryAt  time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("provider %s is rate limited", e.Provider)
+}
+
+// limiter paces calls to one provider with a token bucket and caps the calls
+// in flight. Waiting calls get their turn in the order they came.
+type limiter struct {
+	provider string
+	// nil when the limit is off
+	bucket *rate.Limiter
+	slots  *semaphore.Weighted
+
+	waiting  atomic.Int64
+	inFlight atomic.Int64
+}
+
+func newLimiter(provider string, cfg config.LimitConfig) *limiter {
+	l := &limiter{provider: provider}
+	if cfg.RateLimit > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	if cfg.MaxInFlight > 0 {
+		l.slots = semaphore.NewWeighted(int64(cfg.MaxInFlight))
+	}
+	return l
+}
+
+// wait is block until a call may be made, the returned release must be
+// called when the call is over. It fails when ctx is done, and at once with
+// RateLimitedError when the turn comes after the deadline of ctx.
+func (l *limiter) wait(ctx context.Context) (func(), error) {
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	// a free slot first, so a token is not spent on a call that cannot go
+	if l.slots != nil {
+		if err := l.slots.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+	}
+	release := func() {
+		l.inFlight.Add(-1)
+		if l.slots != nil {
+			l.slots.Release(1)
+		}
+	}
+
+	l.inFlight.Add(1)
+	if l.bucket == nil {
+		return release, nil
+	}
+
+	now := time.Now()
+	turn := l.bucket.ReserveN(now, 1)
+	delay := turn.DelayFrom(now)
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		turn.CancelAt(now)
+		release()
+		return nil, &RateLimitedError{Provider: l.provider, RetryAt: now.Add(delay)}
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			turn.Cancel()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// limiter is the limiter of calls to a provider
+func (s *Service) limiter(provider string) *limiter {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	l, ok := s.limiters[provider]
+	if !ok {
+		l = newLimiter(provider, s.limits(provider))
+		s.limiters[provider] = l
+	}
+	return l
+}
+
+// limits is the limits of a provider, the ones it does not set are the defaults
+func (s *Service) limits(provider string) config.LimitConfig {
+	limits := s.cfg.Limits
+	for _, pc := range s.cfg.Providers {
+		if pc.Name != provider {
+			continue
+		}
+		if pc.RateLimit > 0 {
+			limits.RateLimit = pc.RateLimit
+		}
+		if pc.Burst > 0 {
+			limits.Burst = pc.Burst
+		}
+		if pc.MaxInFlight > 0 {
+			limits.MaxInFlight = pc.MaxInFlight
+		}
+	}
+	return limits
+}
+
+// limiterMetrics is the calls waiting for and holding a turn of every provider
+func (s *Service) limiterMetrics() []metrics.Metric {
+	waiting := metrics.Metric{
+		Name: "cadastral_provider_calls_waiting",
+		Help: "Calls to a provider waiting for their turn.",
+		Type: metrics.Gauge,
+	}
+	inFlight := metrics.Metric{
+		Name: "cadastral_provider_calls_in_flight",
+		Help: "Calls to a provider in flight, counting ones paced by its rate limit.",
+		Type: metrics.Gauge,
+	}
+
+	names := s.providers.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		l := s.limiter(name)
+		labels := map[string]string{"provider": name}
+		waiting.Samples = append(waiting.Samples, metrics.Sample{Labels: labels, Value: float64(l.waiting.Load())})
+		inFlight.Samples = append(inFlight.Samples, metrics.Sample{Labels: labels, Value: float64(l.inFlight.Load())})
+	}
+
+	return []metrics.Metric{waiting, inFlight}
+}
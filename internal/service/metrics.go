@@ -0,0 +1,8 @@
+package service
+
+import "cadastral-service/internal/metrics"
+
+// Metrics is the metrics of the service served on /metrics
+func (s *Service) Metrics() []metrics.Metric {
+	return append(s.breakerMetrics(), s.limiterMetrics()...)
+}
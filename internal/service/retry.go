@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+)
+
+// handleFailure is schedule another attempt for a failed request or move it
+// to a terminal status when the error is permanent or attempts are exhausted
+func (s *Service) handleFailure(ctx context.Context, query *models.Query, callErr error) {
+	policy := s.cfg.Retry
+
+	if !s.isRetryable(callErr) {
+		log.Printf("Query %s failed permanently: %v", query.ID, callErr)
+		s.finish(ctx, query, models.StatusFailed, nil, callErr)
+		return
+	}
+
+	if query.Attempts >= policy.MaxAttempts {
+		log.Printf("Query %s moved to dead letter after %d attempts: %v", query.ID, query.Attempts, callErr)
+		s.finish(ctx, query, models.StatusDeadLetter, nil, callErr)
+		return
+	}
+
+	s.retry(ctx, query, callErr)
+}
+
+// retry is put a failed request back to the queue after a backoff delay
+func (s *Service) retry(ctx context.Context, query *models.Query, callErr error) {
+	policy := s.cfg.Retry
+	delay := backoff(policy.BaseDelay, policy.MaxDelay, policy.Jitter, query.Attempts)
+	log.Printf("Query %s attempt %d failed, retrying in %s: %v", query.ID, query.Attempts, delay, callErr)
+	ok, err := s.repo.RetryQuery(ctx, query.ID, query.ExternalJobID, callErr.Error(), time.Now().Add(delay))
+	if err != nil {
+		log.Printf("Failed to schedule query retry: %v", err)
+		return
+	}
+	if !ok {
+		// cancelled meanwhile or its outcome was stored first by another writer
+		return
+	}
+	s.publish(query, models.StatusPending, nil, callErr)
+}
+
+// isRetryable is tell whether another attempt may succeed
+func (s *Service) isRetryable(err error) bool {
+	var statusErr *provider.StatusError
+	if errors.As(err, &statusErr) {
+		for _, code := range s.cfg.Retry.RetryableStatusCodes {
+			if code == statusErr.StatusCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	// transport problems: refused connections, resets, timeouts, lost
+	// callbacks, and turns a rate limit did not give in time
+	var netErr net.Error
+	var limitErr *RateLimitedError
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCallbackTimeout) ||
+		errors.As(err, &limitErr)
+}
+
+// backoff is exponential delay before the next attempt with random jitter
+func backoff(base, max time.Duration, jitter float64, attempt int) time.Duration {
+	delay := float64(base) * math.Pow(2, float64(attempt-1))
+	if max > 0 && delay > float64(max) {
+		delay = float64(max)
+	}
+
+	if jitter > 0 {
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
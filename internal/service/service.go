@@ -1,102 +1,447 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"cadastral-service/internal/cache"
 	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
 	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
 	"cadastral-service/internal/repository"
+	"cadastral-service/pkg/idgen"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type Service struct {
-	repo *repository.Repository
-	cfg  *config.Config
-}
+	repo   repository.Store
+	events events.Broker
+	cfg    *config.Config
+	// providers check requests, each request names one
+	providers *provider.Registry
+
+	// results is nil when caching is off, calls coalesces identical
+	// requests processed at the same time into one external call
+	results cache.Cache
+	calls   singleflight.Group
+
+	// running is cancel functions of requests being processed
+	runningMu sync.Mutex
+	running   map[string]context.CancelCauseFunc
+
+	// breakers and limiters of providers are made on first use
+	breakersMu sync.Mutex
+	breakers   map[string]*breaker
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+
+	// webhookClient delivers callbacks, it has its own timeout
+	webhookClient *http.Client
 
-type ExternalServerResponse struct {
-	Result bool    `json:"result"`
-	Delay  float64 `json:"delay"`
+	// worker pool state
+	wake        chan struct{}
+	webhookWake chan struct{}
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
 }
 
-func NewService(repo *repository.Repository, cfg *config.Config) *Service {
+var (
+	// ErrQueueFull is returned by Submit when the queue reached its depth
+	ErrQueueFull = errors.New("query queue is full")
+	// ErrUserQueueFull is returned by Submit when the user has too many pending requests
+	ErrUserQueueFull = errors.New("too many pending queries for user")
+)
+
+// NewService is the service over repo, results may be nil to turn caching off
+func NewService(repo repository.Store, broker events.Broker, cfg *config.Config, providers *provider.Registry, results cache.Cache) *Service {
 	return &Service{
-		repo: repo,
-		cfg:  cfg,
+		repo:          repo,
+		events:        broker,
+		cfg:           cfg,
+		providers:     providers,
+		results:       results,
+		running:       make(map[string]context.CancelCauseFunc),
+		breakers:      make(map[string]*breaker),
+		limiters:      make(map[string]*limiter),
+		webhookClient: newWebhookClient(cfg.Webhook),
+		wake:          make(chan struct{}, 1),
+		webhookWake:   make(chan struct{}, 1),
+	}
+}
+
+// Submit is save a new request in the queue and wake up a worker
+func (s *Service) Submit(ctx context.Context, query *models.Query) error {
+	if err := s.checkCapacity(ctx, query.UserID, 1); err != nil {
+		return err
+	}
+
+	if err := s.repo.CreateQuery(ctx, query); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// SubmitBatch is save a batch of requests in one transaction, the whole
+// batch is rejected when the queue has no room for it
+func (s *Service) SubmitBatch(ctx context.Context, batch *models.Batch, queries []*models.Query) error {
+	return s.SubmitImport(ctx, batch, queries, nil)
+}
+
+// SubmitImport is SubmitBatch which also stores the report of an imported file
+func (s *Service) SubmitImport(ctx context.Context, batch *models.Batch, queries []*models.Query, report []models.ImportRow) error {
+	if err := s.checkCapacity(ctx, batch.UserID, len(queries)); err != nil {
+		return err
+	}
+
+	if err := s.repo.CreateImport(ctx, batch, queries, report); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// ResolveProvider is the name of the provider that checks requests naming
+// name, the default one for an empty name. ok is false for unknown names.
+func (s *Service) ResolveProvider(name string) (string, bool) {
+	p, ok := s.providers.Get(name)
+	if !ok {
+		return "", false
+	}
+	return p.Name(), true
+}
+
+// RetryAfter is how long a client should wait after the queue was full
+func (s *Service) RetryAfter() time.Duration {
+	return s.cfg.Queue.RetryAfter
+}
+
+// checkCapacity is reject n new requests when the queue is saturated
+func (s *Service) checkCapacity(ctx context.Context, userID string, n int) error {
+	if s.cfg.Queue.MaxDepth > 0 {
+		depth, err := s.repo.CountPendingQueries(ctx, "")
+		if err != nil {
+			return err
+		}
+		if depth+n > s.cfg.Queue.MaxDepth {
+			return ErrQueueFull
+		}
+	}
+
+	if s.cfg.Queue.MaxPerUser > 0 && userID != "" {
+		depth, err := s.repo.CountPendingQueries(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if depth+n > s.cfg.Queue.MaxPerUser {
+			return ErrUserQueueFull
+		}
 	}
+
+	return nil
 }
 
-// ProcessQuery is proccess request asynchron
-func (s *Service) ProcessQuery(query *models.Query) {
-	// update status on processing
-	if err := s.repo.UpdateQuery(nil, query.ID, "processing", nil); err != nil {
-		log.Printf("Failed to update query status: %v", err)
+// Requeue is give a dead-lettered, failed or timed out request another round of attempts
+func (s *Service) Requeue(ctx context.Context, id, userID string) (bool, error) {
+	ok, err := s.repo.RequeueQuery(ctx, id, userID)
+	if ok {
+		s.events.Publish(events.Event{QueryID: id, Status: models.StatusPending})
+		s.notify()
+	}
+	return ok, err
+}
+
+// Subscribe is return status transitions of one request
+func (s *Service) Subscribe(queryID string) (<-chan events.Event, func()) {
+	return s.events.Subscribe(queryID)
+}
+
+// ProcessQuery is proccess a request claimed from the queue
+func (s *Service) ProcessQuery(ctx context.Context, query *models.Query) {
+	// the lease of a request waiting for a callback ran out
+	if query.ExternalJobID != "" {
+		if pastDeadline(query) {
+			s.handleTimeout(ctx, query)
+			return
+		}
+		s.apply(ctx, query, false, false, ErrCallbackTimeout)
 		return
 	}
 
+	// cancelling the request or passing its deadline aborts its calls
+	ctx, done := s.track(ctx, query.ID)
+	defer done()
+	ctx, cancel := s.withDeadline(ctx, query)
+	defer cancel()
+
+	s.publish(query, models.StatusProcessing, nil, nil)
+
 	// imitate sending on external server
-	result, err := s.callExternalServer(query)
+	result, cached, err := s.check(ctx, query)
+	s.apply(ctx, query, result, cached, err)
+}
+
+// apply is move a request on by the outcome of its check: finish it,
+// schedule a retry or leave it to wait for a callback
+func (s *Service) apply(ctx context.Context, query *models.Query, result, cached bool, err error) {
+	if errors.Is(err, errAwaitingCallback) || errors.Is(context.Cause(ctx), ErrQueryCancelled) {
+		return
+	}
+	expired := timedOut(ctx)
+	if expired {
+		// the outcome is stored after the deadline
+		ctx = context.WithoutCancel(ctx)
+	}
 	if err != nil {
-		log.Printf("Failed to call external server: %v", err)
-		s.repo.UpdateQuery(nil, query.ID, "failed", nil)
+		if expired {
+			s.handleTimeout(ctx, query)
+			return
+		}
+		if ctx.Err() != nil {
+			// worker is stopping, give the request back to the queue
+			s.release(query, time.Time{}, nil)
+			return
+		}
+		var openErr *CircuitOpenError
+		if errors.As(err, &openErr) {
+			s.hold(query, openErr.RetryAt, openErr)
+			return
+		}
+		var limitErr *RateLimitedError
+		if errors.As(err, &limitErr) {
+			s.hold(query, limitErr.RetryAt, limitErr)
+			return
+		}
+		s.handleFailure(ctx, query, err)
 		return
 	}
 
 	// update a result
-	if err := s.repo.UpdateQuery(nil, query.ID, "completed", &result); err != nil {
-		log.Printf("Failed to update query result: %v", err)
+	query.Cached = cached
+	s.finish(ctx, query, models.StatusCompleted, &result, nil)
+}
+
+// check is the result of a request: from its provider, or combined from
+// several providers when it names them. cached is true when no call was
+// made for this request.
+func (s *Service) check(ctx context.Context, query *models.Query) (bool, bool, error) {
+	if len(query.Providers) > 0 {
+		return s.fanOut(ctx, query)
 	}
+	return s.ask(ctx, query, query.Provider)
 }
 
-// callExternalServer is call for a external emulate server
-func (s *Service) callExternalServer(query *models.Query) (bool, error) {
-	// make a data for sending
-	requestData := map[string]interface{}{
-		"cadastral_number": query.CadastralNumber,
-		"latitude":         query.Latitude,
-		"longitude":        query.Longitude,
+// ask is find the answer of one provider in the cache, or share the call of
+// an identical request in flight, or call the provider
+func (s *Service) ask(ctx context.Context, query *models.Query, name string) (bool, bool, error) {
+	p, ok := s.providers.Get(name)
+	if !ok {
+		return false, false, fmt.Errorf("unknown provider %q", name)
+	}
+	key := cache.Key(p.Name(), query.CadastralNumber, query.Latitude, query.Longitude)
+
+	if s.results != nil {
+		result, ok, err := s.results.Get(ctx, key)
+		if err != nil {
+			log.Printf("Failed to read result cache: %v", err)
+		}
+		if ok {
+			return result, true, nil
+		}
+	}
+
+	if cp, ok := p.(provider.CallbackProvider); ok {
+		return s.submitJob(ctx, query, cp)
+	}
+
+	call := func() (interface{}, error) {
+		resp, err := s.call(ctx, query, p)
+		if err == nil {
+			s.cacheResult(ctx, key, resp.Result)
+		}
+		return resp.Result, err
 	}
 
-	jsonData, err := json.Marshal(requestData)
+	called := false
+	value, err, _ := s.calls.Do(key, func() (interface{}, error) {
+		called = true
+		return call()
+	})
+	if err != nil && !called && interrupted(err) && ctx.Err() == nil {
+		// the shared call was cut by its own request, make ours
+		called = true
+		value, err = call()
+	}
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
+	return value.(bool), !called, nil
+}
 
-	// send request on an external server
-	externalServerURL := s.cfg.ExternalServerURL
-	if externalServerURL == "" {
-		externalServerURL = "http://localhost:" + s.cfg.Port + "/api/result"
+// interrupted is tell whether a call failed because its context ended,
+// calls report the cause the context was cancelled with
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrQueryCancelled) || errors.Is(err, ErrQueryTimedOut)
+}
+
+// call is call a provider when its breaker and limits let it
+func (s *Service) call(ctx context.Context, query *models.Query, p provider.Provider) (provider.Response, error) {
+	b := s.breaker(p.Name())
+	generation, err := b.allow()
+	if err != nil {
+		return provider.Response{}, err
 	}
 
-	req, err := http.NewRequest("POST", externalServerURL, bytes.NewBuffer(jsonData))
+	// wait for a turn within the quota of the provider
+	release, err := s.limiter(p.Name()).wait(ctx)
 	if err != nil {
-		return false, err
+		b.done(generation, false, false)
+		return provider.Response{}, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.callExternalServer(ctx, query, p)
+	release()
 
-	client := &http.Client{
-		Timeout: 65 * time.Second, // 60s + 5 for buffer
+	// an error status the provider chose to answer still shows it is up
+	failed := err != nil && s.isRetryable(err)
+	b.done(generation, !failed && ctx.Err() == nil, failed && ctx.Err() == nil)
+	return resp, err
+}
+
+// cacheResult is keep a verdict for identical requests, when caching is on
+func (s *Service) cacheResult(ctx context.Context, key string, result bool) {
+	if s.results == nil {
+		return
 	}
+	if err := s.results.Set(ctx, key, result, s.cfg.Cache.TTL); err != nil {
+		log.Printf("Failed to write result cache: %v", err)
+	}
+}
 
-	resp, err := client.Do(req)
+// finish is store the terminal status of a request, notify subscribers
+// and put its webhook into the outbox
+func (s *Service) finish(ctx context.Context, query *models.Query, status string, result *bool, callErr error) {
+	var ok bool
+	var err error
+	if callErr != nil {
+		ok, err = s.repo.FailQuery(ctx, query.ID, query.ExternalJobID, status, callErr.Error())
+	} else {
+		ok, err = s.repo.UpdateQuery(ctx, query.ID, query.ExternalJobID, status, result, query.Cached)
+	}
 	if err != nil {
-		return false, err
+		log.Printf("Failed to update query %s to %s: %v", query.ID, status, err)
+		return
+	}
+	if !ok {
+		// cancelled meanwhile, or a repeated callback or a reclaim stored
+		// its outcome first; subscribers and the webhook were told already
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("external server returned status: %d", resp.StatusCode)
+	s.publish(query, status, result, callErr)
+	s.enqueueWebhook(ctx, query.ID)
+}
+
+// publish is notify subscribers that a request moved to a new status
+func (s *Service) publish(query *models.Query, status string, result *bool, err error) {
+	event := events.Event{
+		QueryID:  query.ID,
+		Status:   status,
+		Result:   result,
+		Attempts: query.Attempts,
 	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	s.events.Publish(event)
+}
+
+// release is put a request back to pending without counting its attempt,
+// claimable again from nextAttemptAt. It also runs after the worker was
+// stopped, so it does not use the worker context.
+func (s *Service) release(query *models.Query, nextAttemptAt time.Time, reason error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.repo.ReleaseQuery(ctx, query.ID, nextAttemptAt); err != nil {
+		log.Printf("Failed to release query %s: %v", query.ID, err)
+		return
+	}
+	s.publish(query, models.StatusPending, nil, reason)
+}
+
+// hold is keep a request pending until retryAt while the breaker of its
+// provider is open or its quota is used up, it is not burned on calls that
+// cannot be made
+func (s *Service) hold(query *models.Query, retryAt time.Time, reason error) {
+	if earliest := time.Now().Add(s.pollInterval()); retryAt.Before(earliest) {
+		retryAt = earliest
+	}
+	s.release(query, retryAt, reason)
+}
+
+// callExternalServer is check a request with a provider, every call is
+// recorded in query_history
+func (s *Service) callExternalServer(ctx context.Context, query *models.Query, p provider.Provider) (provider.Response, error) {
+	started := time.Now()
+	resp, err := p.Check(ctx, provider.Request{
+		CadastralNumber: query.CadastralNumber,
+		Latitude:        query.Latitude,
+		Longitude:       query.Longitude,
+	})
+
+	// a call cut by stopping worker or cancelling is not an attempt, one
+	// cut by the deadline is
+	if ctx.Err() == nil || timedOut(ctx) {
+		s.recordAttempt(context.WithoutCancel(ctx), &models.QueryAttempt{
+			ID:              idgen.New(),
+			QueryID:         query.ID,
+			Provider:        p.Name(),
+			CadastralNumber: query.CadastralNumber,
+			Attempt:         query.Attempts,
+			Request:         resp.RawRequest,
+			Response:        rawResponse(resp.RawResponse),
+			StatusCode:      resp.StatusCode,
+			LatencyMs:       time.Since(started).Milliseconds(),
+			CreatedAt:       started,
+		}, err)
+	}
+
+	return resp, err
+}
 
-	// lets parcing an answer
-	var response ExternalServerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return false, err
+// recordAttempt is keep one exchange with the external server for debugging
+func (s *Service) recordAttempt(ctx context.Context, attempt *models.QueryAttempt, callErr error) {
+	attempt.Status = models.AttemptSucceeded
+	if callErr != nil {
+		attempt.Status = models.AttemptFailed
+		attempt.Error = callErr.Error()
 	}
 
-	return response.Result, nil
+	if err := s.repo.RecordQueryAttempt(ctx, attempt); err != nil {
+		log.Printf("Failed to record attempt of query %s: %v", attempt.QueryID, err)
+	}
+}
+
+// rawResponse is a body as JSON: kept as is when it is JSON, else as a string
+func rawResponse(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
 }
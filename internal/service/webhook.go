@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+	"cadastral-service/pkg/idgen"
+)
+
+// headers of an outgoing webhook
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// errNoWebhookSecret fails deliveries that have nothing to be signed with,
+// they are never sent unsigned
+var errNoWebhookSecret = errors.New("webhook has no signing secret, register it again")
+
+// WebhookPayload is the body POSTed to a callback URL
+type WebhookPayload struct {
+	Event      string        `json:"event"`
+	DeliveryID string        `json:"delivery_id"`
+	Query      *models.Query `json:"query"`
+}
+
+// errPrivateHost fails deliveries to addresses inside the network of the service
+var errPrivateHost = errors.New("webhook host is a loopback, private or link-local address")
+
+// newWebhookClient is the client of deliveries, it does not connect to
+// private addresses unless they are allowed, whatever the host name resolves to
+func newWebhookClient(cfg config.WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateHosts {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return errPrivateHost
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// PrivateHost is tell whether a URL host is this machine or an address
+// inside the network, names other than localhost are checked when dialed
+func PrivateHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && privateIP(ip)
+}
+
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// SignWebhook is HMAC-SHA256 of "timestamp.body", receivers recompute it
+// with their secret and compare with the signature header
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Redeliver is schedule a failed webhook delivery again
+func (s *Service) Redeliver(ctx context.Context, id, userID string) (bool, error) {
+	ok, err := s.repo.RedeliverWebhook(ctx, id, userID)
+	if ok {
+		wakeUp(s.webhookWake)
+	}
+	return ok, err
+}
+
+// enqueueWebhook is put a callback about a finished request into the
+// outbox when the request or its owner has a callback URL
+func (s *Service) enqueueWebhook(ctx context.Context, queryID string) {
+	query, err := s.repo.GetQueryByID(ctx, queryID)
+	if err != nil {
+		log.Printf("Failed to load query %s for webhook: %v", queryID, err)
+		return
+	}
+
+	url := query.CallbackURL
+	if url == "" && query.UserID != "" {
+		user, err := s.repo.GetUserByID(ctx, query.UserID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to load user %s for webhook: %v", query.UserID, err)
+			return
+		}
+		if user != nil {
+			url = user.WebhookURL
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	delivery := &models.WebhookDelivery{
+		ID:        idgen.New(),
+		QueryID:   query.ID,
+		UserID:    query.UserID,
+		URL:       url,
+		Status:    models.DeliveryPending,
+		CreatedAt: time.Now(),
+	}
+
+	delivery.Payload, err = json.Marshal(WebhookPayload{
+		Event:      "query." + query.Status,
+		DeliveryID: delivery.ID,
+		Query:      query,
+	})
+	if err != nil {
+		log.Printf("Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	if err := s.repo.CreateWebhookDelivery(ctx, delivery); err != nil {
+		log.Printf("Failed to queue webhook for query %s: %v", query.ID, err)
+		return
+	}
+
+	wakeUp(s.webhookWake)
+}
+
+func (s *Service) webhookWorker(ctx context.Context) {
+	defer s.wg.Done()
+
+	pollInterval := s.cfg.Webhook.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	// a claimed delivery is locked a bit longer than one HTTP attempt can take
+	lease := s.cfg.Webhook.Timeout + 30*time.Second
+
+	for {
+		for ctx.Err() == nil {
+			delivery, err := s.repo.ClaimWebhookDelivery(ctx, lease)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to claim webhook delivery: %v", err)
+				}
+				break
+			}
+			if delivery == nil {
+				break
+			}
+			s.deliverWebhook(ctx, delivery)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.webhookWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverWebhook is make one delivery attempt and record it in the log
+func (s *Service) deliverWebhook(ctx context.Context, delivery *models.WebhookDelivery) {
+	started := time.Now()
+	statusCode, err := s.postWebhook(ctx, delivery)
+	if ctx.Err() != nil {
+		// stopping, the expired lease makes the delivery due again
+		return
+	}
+
+	attempt := &models.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		StatusCode: statusCode,
+		DurationMs: time.Since(started).Milliseconds(),
+		CreatedAt:  started,
+	}
+
+	status := models.DeliveryDelivered
+	nextAttemptAt := time.Now()
+	if err != nil {
+		attempt.Error = err.Error()
+		policy := s.cfg.Webhook
+		permanent := errors.Is(err, errNoWebhookSecret) || errors.Is(err, errPrivateHost)
+		if delivery.Attempts >= policy.MaxAttempts || permanent {
+			log.Printf("Webhook %s failed after %d attempts: %v", delivery.ID, delivery.Attempts, err)
+			status = models.DeliveryFailed
+		} else {
+			status = models.DeliveryPending
+			nextAttemptAt = nextAttemptAt.Add(backoff(policy.BaseDelay, policy.MaxDelay, s.cfg.Retry.Jitter, delivery.Attempts))
+		}
+	}
+
+	if err := s.repo.RecordWebhookAttempt(ctx, attempt, status, nextAttemptAt); err != nil {
+		log.Printf("Failed to record webhook attempt: %v", err)
+	}
+}
+
+// postWebhook is send signed payload, any 2xx answer is a success
+func (s *Service) postWebhook(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	if delivery.Secret == "" {
+		return 0, errNoWebhookSecret
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint returned status: %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Start is run the worker pool which drains the query queue.
+// Requests left pending or processing by a previous run are picked up
+// by the same loop, so nothing is lost across restarts.
+func (s *Service) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	workers := s.cfg.Queue.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker(ctx)
+	}
+
+	webhookWorkers := s.cfg.Webhook.Workers
+	if webhookWorkers < 1 {
+		webhookWorkers = 1
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		s.wg.Add(1)
+		go s.webhookWorker(ctx)
+	}
+
+	log.Printf("Started %d query workers and %d webhook workers", workers, webhookWorkers)
+}
+
+// Stop is stop the workers and wait until they return their requests
+func (s *Service) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// notify is wake up one idle worker without blocking
+func (s *Service) notify() {
+	wakeUp(s.wake)
+}
+
+func wakeUp(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pollInterval is how often idle workers look at the queue
+func (s *Service) pollInterval() time.Duration {
+	if s.cfg.Queue.PollInterval <= 0 {
+		return 5 * time.Second
+	}
+	return s.cfg.Queue.PollInterval
+}
+
+func (s *Service) worker(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		// drain the queue until it is empty
+		for ctx.Err() == nil {
+			query, err := s.repo.ClaimQuery(ctx, s.cfg.Queue.LeaseDuration)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to claim query: %v", err)
+				}
+				break
+			}
+			if query == nil {
+				break
+			}
+
+			// there may be more work, let another worker look at it
+			s.notify()
+			s.ProcessQuery(ctx, query)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
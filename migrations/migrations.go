@@ -0,0 +1,9 @@
+// Package migrations embeds the schema of every storage backend: one
+// directory per driver with numbered NNN_name.up.sql and NNN_name.down.sql
+// files, applied in order by database.Migrator.
+package migrations
+
+import "embed"
+
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS query_history;
+DROP TABLE IF EXISTS queries;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_queries_queue;
+ALTER TABLE queries DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,4 @@
+-- lease of a request claimed by a worker, expired leases are picked up again
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_queries_queue ON queries(status, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE queries DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE queries DROP COLUMN IF EXISTS last_error;
+ALTER TABLE queries DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,4 @@
+-- retry bookkeeping of the external server calls
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE users DROP COLUMN IF EXISTS webhook_secret;
+ALTER TABLE users DROP COLUMN IF EXISTS webhook_url;
+ALTER TABLE queries DROP COLUMN IF EXISTS callback_url;
@@ -0,0 +1,37 @@
+-- callback targets: per request or per user
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS callback_url TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS webhook_url TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS webhook_secret TEXT;
+
+-- outbox of webhook deliveries
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id VARCHAR(255) PRIMARY KEY,
+    query_id VARCHAR(255) NOT NULL REFERENCES queries(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    url TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    last_status_code INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_queue ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id);
+
+-- delivery log, one row per HTTP attempt
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id VARCHAR(255) NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
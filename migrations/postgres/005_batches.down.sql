@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_queries_batch_id;
+ALTER TABLE queries DROP COLUMN IF EXISTS batch_id;
+DROP TABLE IF EXISTS batches;
@@ -0,0 +1,11 @@
+-- requests submitted together in one batch
+CREATE TABLE IF NOT EXISTS batches (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) REFERENCES users(id) ON DELETE SET NULL,
+    total INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS batch_id VARCHAR(255) REFERENCES batches(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_queries_batch_id ON queries(batch_id);
@@ -0,0 +1,11 @@
+-- report of file imports: every row of the file, accepted or rejected
+CREATE TABLE IF NOT EXISTS import_rows (
+    batch_id VARCHAR(255) NOT NULL REFERENCES batches(id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    cadastral_number TEXT,
+    latitude TEXT,
+    longitude TEXT,
+    query_id VARCHAR(255),
+    error TEXT,
+    PRIMARY KEY (batch_id, row_number)
+);
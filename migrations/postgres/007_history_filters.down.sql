@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_queries_cadastral_prefix;
+DROP INDEX IF EXISTS idx_queries_user_created_at;
+DROP INDEX IF EXISTS idx_queries_completed_at;
@@ -0,0 +1,5 @@
+-- filtering and sorting of history
+CREATE INDEX IF NOT EXISTS idx_queries_completed_at ON queries(completed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_queries_user_created_at ON queries(user_id, created_at DESC);
+-- LIKE 'prefix%' on cadastral numbers does not use the default index outside the C locale
+CREATE INDEX IF NOT EXISTS idx_queries_cadastral_prefix ON queries(cadastral_number text_pattern_ops);
@@ -0,0 +1,3 @@
+-- keyset pagination of history on (created_at, id)
+CREATE INDEX IF NOT EXISTS idx_queries_created_at_id ON queries(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_queries_user_created_at_id ON queries(user_id, created_at DESC, id DESC);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_query_history_query_id;
+
+ALTER TABLE query_history DROP COLUMN IF EXISTS error;
+ALTER TABLE query_history DROP COLUMN IF EXISTS latency_ms;
+ALTER TABLE query_history DROP COLUMN IF EXISTS status_code;
+ALTER TABLE query_history DROP COLUMN IF EXISTS attempt;
+ALTER TABLE query_history DROP COLUMN IF EXISTS query_id;
@@ -0,0 +1,8 @@
+-- query_history is the log of external server calls, one row per attempt
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS query_id VARCHAR(255) REFERENCES queries(id) ON DELETE CASCADE;
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS attempt INTEGER;
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS status_code INTEGER;
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS latency_ms BIGINT;
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_query_history_query_id ON query_history(query_id, created_at);
@@ -0,0 +1,2 @@
+-- cached marks requests answered without their own external call
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS cached BOOLEAN NOT NULL DEFAULT FALSE;
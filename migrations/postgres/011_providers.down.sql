@@ -0,0 +1,2 @@
+ALTER TABLE query_history DROP COLUMN IF EXISTS provider;
+ALTER TABLE queries DROP COLUMN IF EXISTS provider;
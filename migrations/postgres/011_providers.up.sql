@@ -0,0 +1,3 @@
+-- name of the provider that checks a request and that answered an attempt
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS provider VARCHAR(255);
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS provider VARCHAR(255);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS query_provider_answers;
+
+ALTER TABLE queries DROP COLUMN IF EXISTS disagreement;
+ALTER TABLE queries DROP COLUMN IF EXISTS strategy;
+ALTER TABLE queries DROP COLUMN IF EXISTS providers;
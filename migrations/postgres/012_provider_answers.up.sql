@@ -0,0 +1,17 @@
+-- requests checked by several providers: the list, how answers are combined
+-- and whether the answers differed
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS providers TEXT;
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS strategy VARCHAR(50);
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS disagreement BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- answer of each provider in the last round of a request
+CREATE TABLE IF NOT EXISTS query_provider_answers (
+    query_id VARCHAR(255) NOT NULL REFERENCES queries(id) ON DELETE CASCADE,
+    provider VARCHAR(255) NOT NULL,
+    result BOOLEAN,
+    cached BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    latency_ms BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (query_id, provider)
+);
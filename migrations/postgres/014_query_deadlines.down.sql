@@ -0,0 +1,2 @@
+ALTER TABLE queries DROP COLUMN IF EXISTS retry_on_timeout;
+ALTER TABLE queries DROP COLUMN IF EXISTS timeout_ms;
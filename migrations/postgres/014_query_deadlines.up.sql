@@ -0,0 +1,4 @@
+-- processing deadline a request asked for, NULL is the default one, and
+-- whether it is retried when the deadline passes
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS timeout_ms BIGINT;
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS retry_on_timeout BOOLEAN NOT NULL DEFAULT TRUE;
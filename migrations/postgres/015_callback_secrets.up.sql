@@ -0,0 +1,3 @@
+-- secret signing deliveries to the callback_url of a request, generated
+-- for each request or batch so receivers cannot forge each other's
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS callback_secret TEXT;
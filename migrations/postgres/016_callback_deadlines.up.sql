@@ -0,0 +1,2 @@
+-- deadline of a request waiting for a callback, past it the request times out
+ALTER TABLE queries ADD COLUMN IF NOT EXISTS deadline_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+-- normalized numbers are valid for every version, the old forms are not kept
+SELECT 1;
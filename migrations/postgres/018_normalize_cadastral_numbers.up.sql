@@ -0,0 +1,19 @@
+-- numbers stored before they were validated get the form cadastral.Normalize
+-- gives, so lookups and prefix filters find them; other values are kept
+CREATE FUNCTION pg_temp.normalize_cadastral(input TEXT) RETURNS TEXT AS $$
+    SELECT CASE WHEN n ~ '^0*[0-9]{1,2}:0*[0-9]{1,2}:0*[0-9]{1,7}:0*[1-9][0-9]{0,11}$' THEN
+        lpad(ltrim(split_part(n, ':', 1), '0'), 2, '0') || ':' ||
+        lpad(ltrim(split_part(n, ':', 2), '0'), 2, '0') || ':' ||
+        lpad(ltrim(split_part(n, ':', 3), '0'), 7, '0') || ':' ||
+        ltrim(split_part(n, ':', 4), '0')
+    ELSE input END
+    FROM (SELECT regexp_replace(input, '\s', '', 'g') AS n) compact
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE queries SET cadastral_number = pg_temp.normalize_cadastral(cadastral_number)
+WHERE cadastral_number <> pg_temp.normalize_cadastral(cadastral_number);
+
+UPDATE query_history SET cadastral_number = pg_temp.normalize_cadastral(cadastral_number)
+WHERE cadastral_number <> pg_temp.normalize_cadastral(cadastral_number);
+
+DROP FUNCTION pg_temp.normalize_cadastral(TEXT);
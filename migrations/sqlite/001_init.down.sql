@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS import_rows;
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS query_history;
+DROP TABLE IF EXISTS queries;
+DROP TABLE IF EXISTS batches;
+DROP TABLE IF EXISTS users;
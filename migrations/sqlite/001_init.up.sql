@@ -0,0 +1,114 @@
+-- SQLite schema for single-node deployments (DATABASE_URL=sqlite://...),
+-- timestamps are fixed width UTC text so they compare as strings
+
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    webhook_url TEXT,
+    webhook_secret TEXT,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS batches (
+    id TEXT PRIMARY KEY,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    total INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS queries (
+    id TEXT PRIMARY KEY,
+    cadastral_number TEXT NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    result BOOLEAN,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now')),
+    completed_at TIMESTAMP,
+    locked_until TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP,
+    callback_url TEXT,
+    batch_id TEXT REFERENCES batches(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_queries_cadastral ON queries(cadastral_number);
+
+CREATE INDEX IF NOT EXISTS idx_queries_user_id ON queries(user_id);
+
+CREATE INDEX IF NOT EXISTS idx_queries_status ON queries(status);
+
+CREATE INDEX IF NOT EXISTS idx_queries_queue ON queries(status, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_queries_batch_id ON queries(batch_id);
+
+CREATE INDEX IF NOT EXISTS idx_queries_completed_at ON queries(completed_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_queries_created_at_id ON queries(created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_queries_user_created_at_id ON queries(user_id, created_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS query_history (
+    id TEXT PRIMARY KEY,
+    cadastral_number TEXT NOT NULL,
+    request_data TEXT NOT NULL,
+    response_data TEXT,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_history_cadastral ON query_history(cadastral_number);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    query_id TEXT NOT NULL REFERENCES queries(id) ON DELETE CASCADE,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    url TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    last_status_code INTEGER,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now')),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_queue ON webhook_deliveries(status, next_attempt_at);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery_id TEXT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
+
+CREATE TABLE IF NOT EXISTS import_rows (
+    batch_id TEXT NOT NULL REFERENCES batches(id) ON DELETE CASCADE,
+    row_number INTEGER NOT NULL,
+    cadastral_number TEXT,
+    latitude TEXT,
+    longitude TEXT,
+    query_id TEXT,
+    error TEXT,
+    PRIMARY KEY (batch_id, row_number)
+);
+
+INSERT INTO users (id, username, password_hash, created_at)
+ VALUES (
+    'admin_001',
+    'admin',
+    '$2a$10$N9qo8uLOickgx2ZMRZoMyeS7.2Y5Z1e8Z5c6W5q5k5n5v5c5n5v5c5n',
+    strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now')
+ ) ON CONFLICT (username) DO NOTHING;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_query_history_query_id;
+
+ALTER TABLE query_history DROP COLUMN error;
+ALTER TABLE query_history DROP COLUMN latency_ms;
+ALTER TABLE query_history DROP COLUMN status_code;
+ALTER TABLE query_history DROP COLUMN attempt;
+ALTER TABLE query_history DROP COLUMN query_id;
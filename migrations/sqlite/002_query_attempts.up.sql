@@ -0,0 +1,9 @@
+-- query_history is the log of external server calls, one row per attempt.
+-- query_id has no foreign key: SQLite cannot drop such a column on rollback.
+ALTER TABLE query_history ADD COLUMN query_id TEXT;
+ALTER TABLE query_history ADD COLUMN attempt INTEGER;
+ALTER TABLE query_history ADD COLUMN status_code INTEGER;
+ALTER TABLE query_history ADD COLUMN latency_ms INTEGER;
+ALTER TABLE query_history ADD COLUMN error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_query_history_query_id ON query_history(query_id, created_at);
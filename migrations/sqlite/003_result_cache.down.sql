@@ -0,0 +1 @@
+ALTER TABLE queries DROP COLUMN cached;
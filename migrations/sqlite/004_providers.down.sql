@@ -0,0 +1,2 @@
+ALTER TABLE query_history DROP COLUMN provider;
+ALTER TABLE queries DROP COLUMN provider;
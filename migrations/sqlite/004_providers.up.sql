@@ -0,0 +1,3 @@
+-- name of the provider that checks a request and that answered an attempt
+ALTER TABLE queries ADD COLUMN provider TEXT;
+ALTER TABLE query_history ADD COLUMN provider TEXT;
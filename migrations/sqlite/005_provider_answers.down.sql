@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS query_provider_answers;
+
+ALTER TABLE queries DROP COLUMN disagreement;
+ALTER TABLE queries DROP COLUMN strategy;
+ALTER TABLE queries DROP COLUMN providers;
@@ -0,0 +1,17 @@
+-- requests checked by several providers: the list, how answers are combined
+-- and whether the answers differed
+ALTER TABLE queries ADD COLUMN providers TEXT;
+ALTER TABLE queries ADD COLUMN strategy TEXT;
+ALTER TABLE queries ADD COLUMN disagreement BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- answer of each provider in the last round of a request
+CREATE TABLE IF NOT EXISTS query_provider_answers (
+    query_id TEXT NOT NULL REFERENCES queries(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    result BOOLEAN,
+    cached BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    latency_ms INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000000+00:00', 'now')),
+    PRIMARY KEY (query_id, provider)
+);
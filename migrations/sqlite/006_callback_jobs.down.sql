@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_queries_external_job;
+
+ALTER TABLE queries DROP COLUMN external_job_id;
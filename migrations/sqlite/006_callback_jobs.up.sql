@@ -0,0 +1,4 @@
+-- job of a provider answering by callback, the callback finds the request by it
+ALTER TABLE queries ADD COLUMN external_job_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_queries_external_job ON queries(provider, external_job_id);
@@ -0,0 +1,2 @@
+ALTER TABLE queries DROP COLUMN retry_on_timeout;
+ALTER TABLE queries DROP COLUMN timeout_ms;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS early_callbacks;
@@ -0,0 +1,13 @@
+-- callbacks that came before the job they are about was stored, taken by
+-- the request that submitted the job once it stored it
+CREATE TABLE IF NOT EXISTS early_callbacks (
+    provider TEXT NOT NULL,
+    job_id TEXT NOT NULL,
+    result BOOLEAN NOT NULL,
+    error TEXT,
+    body BLOB,
+    received_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, job_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_early_callbacks_received ON early_callbacks(received_at);
@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cadastral-service/migrations"
+)
+
+// migrationLockKey is the Postgres advisory lock held while migrating, so
+// instances starting together do not apply the same migration twice
+const migrationLockKey = 720_137_414
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is SHA-256 of the up file, an applied migration must not change
+	Checksum string
+}
+
+// MigrationStatus is a migration and its state in the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+	// Modified is an applied migration whose up file has changed since
+	Modified bool
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies the embedded migrations of one driver and records them
+// in schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator is migrator of the embedded migrations/<driver> files,
+// driver is postgres or sqlite
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	list, err := LoadMigrations(migrations.FS, driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: list}, nil
+}
+
+// Migrate is apply every pending migration, it is run on start
+func Migrate(ctx context.Context, db *sql.DB, driver string) error {
+	m, err := NewMigrator(db, driver)
+	if err != nil {
+		return err
+	}
+	return m.Up(ctx)
+}
+
+// LoadMigrations is read NNN_name.up.sql and NNN_name.down.sql files of a
+// directory ordered by version
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+
+		if match[3] == "up" {
+			mig.Up = string(data)
+			sum := sha256.Sum256(data)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", mig.Version)
+		}
+		list = append(list, *mig)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no migrations in %s", dir)
+	}
+	return list, nil
+}
+
+// Up is apply every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down is roll back the last steps applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var applied []int
+	for _, status := range statuses {
+		if status.Applied {
+			applied = append(applied, status.Version)
+		}
+	}
+	if steps > len(applied) {
+		steps = len(applied)
+	}
+
+	target := 0
+	if i := len(applied) - steps - 1; i >= 0 {
+		target = applied[i]
+	}
+	return m.Goto(ctx, target)
+}
+
+// Goto is migrate up or down to version, 0 rolls back everything
+func (m *Migrator) Goto(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+				if err := m.apply(ctx, conn, mig, true); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > version {
+				if err := m.apply(ctx, conn, mig, false); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status is every known migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			status := MigrationStatus{Migration: mig}
+			if a, ok := applied[mig.Version]; ok {
+				appliedAt := a.appliedAt
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+				status.Modified = a.checksum != mig.Checksum
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// verify is refuse to migrate a database whose history does not match the files
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	for version, a := range applied {
+		mig := m.find(version)
+		if mig == nil {
+			return fmt.Errorf("database has migration %d which is unknown to this build", version)
+		}
+		if a.checksum != mig.Checksum {
+			return fmt.Errorf("migration %d_%s was modified after it was applied", mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// apply is run one migration in a transaction. The version is checked again
+// inside it, another instance may have applied it meanwhile.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	direction, script := "up", mig.Up
+	if !up {
+		direction, script = "down", mig.Down
+		if strings.TrimSpace(script) == "" {
+			return fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, m.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`), mig.Version).Scan(&count); err != nil {
+		return err
+	}
+	if (count > 0) == up {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d_%s %s failed: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.rebind(`
+			INSERT INTO schema_migrations (version, name, checksum, applied_at)
+			VALUES ($1, $2, $3, $4)
+		`), mig.Version, mig.Name, mig.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.rebind(`DELETE FROM schema_migrations WHERE version = $1`), mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Migration %03d_%s %s", mig.Version, mig.Name, direction)
+	return nil
+}
+
+// withLock is run fn on one connection holding the migration lock. SQLite
+// needs none: every migration runs in an immediate, exclusive transaction.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.driver == "postgres" {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+			return fmt.Errorf("failed to take migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}
+
+	createTable := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL
+		)`
+	if m.driver == "sqlite" {
+		createTable = strings.Replace(createTable, "TIMESTAMP WITH TIME ZONE", "TIMESTAMP", 1)
+	}
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// rebind is use SQLite numbered parameters instead of $n
+func (m *Migrator) rebind(query string) string {
+	if m.driver == "sqlite" {
+		return strings.ReplaceAll(query, "$", "?")
+	}
+	return query
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqlitePragmas are set on every connection: foreign keys like in Postgres,
+// WAL so readers do not block the writer, and waiting on a busy database
+// instead of failing
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+// SQLitePath is the file of a sqlite:// URL: sqlite://data/cadastral.db is
+// relative, sqlite:///var/lib/cadastral.db is absolute
+func SQLitePath(databaseURL string) string {
+	path := strings.TrimPrefix(databaseURL, "sqlite:")
+	return strings.TrimPrefix(path, "//")
+}
+
+func NewSQLite(databaseURL string) (*sql.DB, error) {
+	path := SQLitePath(databaseURL)
+	if path == "" {
+		return nil, fmt.Errorf("sqlite database path is empty")
+	}
+
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + sqlitePragmas
+	} else {
+		dsn += "?" + sqlitePragmas
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// every connection to :memory: is a separate empty database
+	if strings.HasPrefix(path, ":memory:") {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	log.Printf("Opened SQLite database %s", path)
+	return db, nil
+}
@@ -0,0 +1,20 @@
+package idgen
+
+import (
+	"math/rand"
+	"time"
+)
+
+// New is generate a sortable ID: creation time plus random suffix
+func New() string {
+	return time.Now().Format("20060102150405") + randomString(6)
+}
+
+func randomString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
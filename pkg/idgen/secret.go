@@ -0,0 +1,15 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Secret is generate a random 256-bit key for signing, hex encoded
+func Secret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("idgen: no randomness: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/models"
+)
+
+func TestQueueBackpressure(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	cfg := testConfig(external.URL)
+	cfg.Queue.MaxDepth = 2
+	cfg.Queue.RetryAfter = 1500 * time.Millisecond
+	router, svc := newTestRouter(t, withConfig(cfg))
+
+	body := map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	}
+	var ids []string
+	for i := 0; i < cfg.Queue.MaxDepth; i++ {
+		w := doJSON(router, "POST", "/api/v1/query", body)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		ids = append(ids, created.ID)
+	}
+
+	// nothing drains the queue yet, so a full queue turns requests away
+	w := doJSON(router, "POST", "/api/v1/query", body)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w = doJSON(router, "POST", "/api/v1/queries/batch", []interface{}{body})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	for _, id := range ids {
+		require.Eventually(t, func() bool {
+			var query api.QueryResponse
+			w := doJSON(router, "GET", "/api/v1/query/"+id, nil)
+			return json.Unmarshal(w.Body.Bytes(), &query) == nil && query.Status == models.StatusCompleted
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// once drained the queue accepts requests again
+	w = doJSON(router, "POST", "/api/v1/query", body)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
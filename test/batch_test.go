@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/models"
+)
+
+func batchItem(number string, latitude float64) map[string]interface{} {
+	return map[string]interface{}{
+		"cadastral_number": number,
+		"latitude":         latitude,
+		"longitude":        37.61,
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	cfg := testConfig("http://localhost:0")
+	cfg.Queue.MaxDepth = 0
+	router, _ := newTestRouter(t, withConfig(cfg))
+
+	items := make([]map[string]interface{}, 1001)
+	for i := range items {
+		items[i] = batchItem("77:01:0001001:1234", 55.75)
+	}
+	w := doJSON(router, "POST", "/api/v1/queries/batch", items)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "limited to 1000 queries")
+
+	// the body is cut before it is decoded
+	cfg.MaxUploadSize = 1024
+	w = doJSON(router, "POST", "/api/v1/queries/batch", items)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "limited to 1024 bytes")
+}
+
+func TestCreateBatch(t *testing.T) {
+	router, svc := newTestRouter(t, withExternalServer(verdictServer(t, true, 0).URL))
+
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "POST", "/api/v1/queries/batch", []interface{}{}).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "POST", "/api/v1/queries/batch", map[string]string{"not": "an array"}).Code)
+
+	// every item is invalid, nothing is saved
+	w := doJSON(router, "POST", "/api/v1/queries/batch", []interface{}{batchItem("1234", 55.75)})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var rejected api.BatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rejected))
+	assert.Empty(t, rejected.BatchID)
+	assert.Equal(t, 1, rejected.Rejected)
+
+	w = doJSON(router, "POST", "/api/v1/queries/batch", []interface{}{
+		batchItem("77:01:0001001:1234", 55.75),
+		batchItem("77:01:0001001:1235", 95),
+		batchItem("77:01:0001001:1236", 55.75),
+		batchItem(" 77:1:1001:1237 ", 55.75),
+	})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created api.BatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.BatchID)
+	assert.Equal(t, 3, created.Accepted)
+	assert.Equal(t, 1, created.Rejected)
+	require.Len(t, created.Items, 4)
+	for i, item := range created.Items {
+		assert.Equal(t, i, item.Index)
+	}
+	assert.NotEmpty(t, created.Items[0].ID)
+	assert.Empty(t, created.Items[1].ID)
+	assert.Contains(t, created.Items[1].Error, "latitude")
+	assert.NotEmpty(t, created.Items[3].ID)
+
+	progress := func() api.BatchProgressResponse {
+		var progress api.BatchProgressResponse
+		require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/queries/batch/"+created.BatchID, nil).Body.Bytes(), &progress))
+		return progress
+	}
+
+	// no worker ran yet
+	before := progress()
+	assert.Equal(t, 3, before.Total)
+	assert.Equal(t, 0, before.Finished)
+	assert.Equal(t, map[string]int{models.StatusPending: 3}, before.Statuses)
+	assert.False(t, before.Done)
+
+	svc.Start(context.Background())
+	defer svc.Stop()
+	for _, item := range created.Items {
+		if item.ID != "" {
+			doJSON(router, "GET", "/api/v1/query/"+item.ID+"?wait=5s", nil)
+		}
+	}
+
+	after := progress()
+	assert.Equal(t, 3, after.Finished)
+	assert.Equal(t, 1.0, after.Progress)
+	assert.Equal(t, map[string]int{models.StatusCompleted: 3}, after.Statuses)
+	assert.True(t, after.Done)
+
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", "/api/v1/queries/batch/missing", nil).Code)
+}
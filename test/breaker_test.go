@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/metrics"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/service"
+)
+
+func TestCircuitBreakerHoldsQueries(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	cfg := testConfig(external.URL)
+	cfg.Retry = config.RetryConfig{MaxAttempts: 10, BaseDelay: time.Millisecond, RetryableStatusCodes: []int{503}}
+	cfg.Breaker = config.BreakerConfig{FailureThreshold: 2, OpenDuration: 300 * time.Millisecond, HalfOpenRequests: 1}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	require.Eventually(t, func() bool {
+		return svc.Breakers()[0].State != service.BreakerClosed
+	}, 5*time.Second, 5*time.Millisecond)
+
+	var status api.StatusResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/status", nil).Body.Bytes(), &status))
+	assert.Equal(t, "degraded", status.Status)
+	require.Len(t, status.Providers, 1)
+	assert.Equal(t, "default", status.Providers[0].Provider)
+	assert.GreaterOrEqual(t, status.Providers[0].Opens, 1)
+
+	w = doJSON(router, "GET", "/metrics", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `cadastral_provider_breaker_opens_total{provider="default"}`)
+
+	// while the breaker is open the request stays pending
+	var held api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID, nil).Body.Bytes(), &held))
+	assert.Contains(t, []string{models.StatusPending, models.StatusProcessing}, held.Status)
+
+	healthy.Store(true)
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	// held rounds are not attempts, only calls are
+	assert.Equal(t, int(calls.Load()), done.Attempts)
+	assert.Equal(t, service.BreakerClosed, svc.Breakers()[0].State)
+}
+
+func TestStaleCallDoesNotCloseBreaker(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CadastralNumber string `json:"cadastral_number"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.CadastralNumber != "77:01:0001001:1234" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		started <- struct{}{}
+		<-release
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	cfg := testConfig(external.URL)
+	cfg.Retry = config.RetryConfig{MaxAttempts: 1, RetryableStatusCodes: []int{503}}
+	cfg.Breaker = config.BreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute, HalfOpenRequests: 1}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	submit := func(number string) string {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": number,
+			"latitude":         55.75,
+			"longitude":        37.61,
+		})
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created.ID
+	}
+
+	// a slow call is allowed while the breaker is closed
+	slow := submit("77:01:0001001:1234")
+	<-started
+
+	// meanwhile another call fails and opens the breaker
+	var failed api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+submit("77:01:0001001:1235")+"?wait=5s", nil).Body.Bytes(), &failed))
+	require.Equal(t, models.StatusDeadLetter, failed.Status)
+	require.Equal(t, service.BreakerOpen, svc.Breakers()[0].State)
+
+	// the slow call succeeds, but it was not a probe
+	close(release)
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+slow+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	assert.Equal(t, service.BreakerOpen, svc.Breakers()[0].State)
+}
+
+func TestMetricsFormat(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, metrics.Write(&buf, []metrics.Metric{{
+		Name: "calls_total",
+		Help: "Calls made.",
+		Type: metrics.Counter,
+		Samples: []metrics.Sample{
+			{Labels: map[string]string{"provider": "a", "code": "200"}, Value: 3},
+			{Value: 0.5},
+		},
+	}}))
+
+	assert.Equal(t, "# HELP calls_total Calls made.\n"+
+		"# TYPE calls_total counter\n"+
+		"calls_total{code=\"200\",provider=\"a\"} 3\n"+
+		"calls_total 0.5\n", buf.String())
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/cache"
+	"cadastral-service/internal/config"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewLRU(2)
+
+	require.NoError(t, c.Set(ctx, "a", true, time.Minute))
+	require.NoError(t, c.Set(ctx, "b", false, time.Minute))
+
+	result, ok, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, result)
+
+	// b is the least recently used now
+	require.NoError(t, c.Set(ctx, "c", true, time.Minute))
+	_, ok, _ = c.Get(ctx, "b")
+	assert.False(t, ok)
+	_, ok, _ = c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	require.NoError(t, c.Set(ctx, "short", true, 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, ok, _ = c.Get(ctx, "short")
+	assert.False(t, ok)
+}
+
+func TestNewCache(t *testing.T) {
+	c, err := cache.New(config.CacheConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = cache.New(config.CacheConfig{TTL: time.Minute, Size: 10})
+	require.NoError(t, err)
+	assert.IsType(t, &cache.LRU{}, c)
+
+	_, err = cache.New(config.CacheConfig{TTL: time.Minute, RedisURL: "not a url"})
+	assert.Error(t, err)
+
+	assert.Equal(t, "default|77:01:0001001:1234|55.750000|37.610000", cache.Key("default", "77:01:0001001:1234", 55.75, 37.61))
+}
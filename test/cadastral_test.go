@@ -0,0 +1,80 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"cadastral-service/internal/cadastral"
+)
+
+func TestCadastralNormalize(t *testing.T) {
+	cases := map[string]string{
+		"77:01:0001001:1234":        "77:01:0001001:1234",
+		" 77 : 01 : 0001001 : 1234": "77:01:0001001:1234",
+		"7:1:1001:01234":            "07:01:0001001:1234",
+		"50:21:000101:5":            "50:21:0000101:5",
+		"077:001:0001001:1234":      "77:01:0001001:1234",
+	}
+
+	for input, expected := range cases {
+		number, err := cadastral.Normalize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, number, input)
+	}
+}
+
+func TestCadastralNormalizePrefix(t *testing.T) {
+	cases := map[string]string{
+		"7":                 "7",
+		"77:":               "77:",
+		"7:1":               "07:1",
+		"7:1:":              "07:01:",
+		" 77 : 1 : 1001 :":  "77:01:0001001:",
+		"077:01:0001001:12": "77:01:0001001:12",
+	}
+
+	for input, expected := range cases {
+		prefix, err := cadastral.NormalizePrefix(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, prefix, input)
+	}
+
+	for _, input := range []string{"77::", "7a:", "77:0a", "777:", "77:01:0001001:1:2"} {
+		_, err := cadastral.NormalizePrefix(input)
+		var cerr *cadastral.Error
+		assert.True(t, errors.As(err, &cerr), input)
+	}
+}
+
+func TestCadastralParse(t *testing.T) {
+	n, err := cadastral.Parse("77:01:0001001:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, cadastral.Number{District: "77", Area: "01", Block: "0001001", Parcel: "1234"}, n)
+}
+
+func TestCadastralParseErrors(t *testing.T) {
+	cases := []struct {
+		input   string
+		segment string
+	}{
+		{"", ""},
+		{"77:01:0001001", ""},
+		{"77:01:0001001:1234:5", ""},
+		{"7a:01:0001001:1234", "district"},
+		{"777:01:0001001:1234", "district"},
+		{"77::0001001:1234", "area"},
+		{"77:01:12345678:1234", "block"},
+		{"77:01:0001001:12-34", "parcel"},
+		{"77:01:0001001:000", "parcel"},
+	}
+
+	for _, tc := range cases {
+		_, err := cadastral.Parse(tc.input)
+		var cerr *cadastral.Error
+		if assert.True(t, errors.As(err, &cerr), tc.input) {
+			assert.Equal(t, tc.segment, cerr.Segment, tc.input)
+		}
+	}
+}
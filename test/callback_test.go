@@ -0,0 +1,374 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/repository"
+	"cadastral-service/internal/repository/memory"
+)
+
+const callbackSecret = "s3cret"
+
+// jobServer is a provider in callback mode that takes every request as a job
+func jobServer(t *testing.T, callbackURL *atomic.Value) *httptest.Server {
+	var jobs atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		callbackURL.Store(req["callback_url"])
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"job_id": "job-" + strconv.Itoa(int(jobs.Add(1)))})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// callBack is deliver a signed callback to the service
+func callBack(router *gin.Engine, secret string, body string) *httptest.ResponseRecorder {
+	timestamp := time.Now().Unix()
+	req := httptest.NewRequest("POST", "/api/v1/callbacks/async", bytes.NewBufferString(body))
+	req.Header.Set(provider.CallbackTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(provider.CallbackSignatureHeader, provider.SignCallback(secret, timestamp, []byte(body)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func callbackConfig(url string, timeout time.Duration) *config.Config {
+	cfg := testConfig("")
+	cfg.CallbackBaseURL = "http://service.example/"
+	cfg.Providers = []config.ProviderConfig{{
+		Name:            "async",
+		URL:             url,
+		Mode:            config.ProviderCallback,
+		CallbackSecret:  callbackSecret,
+		CallbackTimeout: timeout,
+	}}
+	return cfg
+}
+
+func TestCallbackProvider(t *testing.T) {
+	var callbackURL atomic.Value
+	router, svc := newTestRouter(t, withConfig(callbackConfig(jobServer(t, &callbackURL).URL, time.Minute)))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// the job is submitted and the request waits for its callback
+	require.Eventually(t, func() bool {
+		return callbackURL.Load() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "http://service.example/api/v1/callbacks/async", callbackURL.Load())
+	assert.Equal(t, http.StatusOK, callBack(router, callbackSecret, `{"job_id":"job-1","result":true}`).Code)
+
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	if assert.NotNil(t, done.Result) {
+		assert.True(t, *done.Result)
+	}
+
+	var attempts []models.QueryAttempt
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"/attempts", nil).Body.Bytes(), &attempts))
+	if assert.Len(t, attempts, 2) {
+		assert.Equal(t, http.StatusAccepted, attempts[0].StatusCode)
+		assert.JSONEq(t, `{"job_id":"job-1","result":true}`, string(attempts[1].Response))
+	}
+
+	// the job is done, a repeated callback changes nothing and a forged one is refused
+	assert.Equal(t, http.StatusOK, callBack(router, callbackSecret, `{"job_id":"job-1","result":false}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, callBack(router, "guess", `{"job_id":"job-1","result":false}`).Code)
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID, nil).Body.Bytes(), &done))
+	if assert.NotNil(t, done.Result) {
+		assert.True(t, *done.Result)
+	}
+}
+
+func TestEarlyCallback(t *testing.T) {
+	var router atomic.Value
+	// the provider calls back before it answers the submit
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		callBack(router.Load().(*gin.Engine), callbackSecret, `{"job_id":"job-1","result":true}`)
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"job_id": "job-1"})
+	}))
+	defer server.Close()
+
+	r, svc := newTestRouter(t, withConfig(callbackConfig(server.URL, time.Minute)))
+	router.Store(r)
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(r, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// the callback is kept until the job is stored
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(r, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	if assert.NotNil(t, done.Result) {
+		assert.True(t, *done.Result)
+	}
+	assert.Equal(t, 1, done.Attempts)
+
+	var attempts []models.QueryAttempt
+	require.NoError(t, json.Unmarshal(doJSON(r, "GET", "/api/v1/query/"+created.ID+"/attempts", nil).Body.Bytes(), &attempts))
+	assert.Len(t, attempts, 2)
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	var callbackURL atomic.Value
+	cfg := callbackConfig(jobServer(t, &callbackURL).URL, 50*time.Millisecond)
+	cfg.Retry = config.RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// no callback comes, the job is submitted again until attempts run out
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusDeadLetter, done.Status)
+	assert.Contains(t, done.LastError, "did not call back")
+	assert.Equal(t, 2, done.Attempts)
+
+	var attempts []models.QueryAttempt
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"/attempts", nil).Body.Bytes(), &attempts))
+	assert.Len(t, attempts, 2)
+
+	// fanned out requests cannot use it
+	w = doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+		"providers":        []string{"async"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCallbackDeadline(t *testing.T) {
+	var callbackURL atomic.Value
+	cfg := callbackConfig(jobServer(t, &callbackURL).URL, time.Minute)
+	cfg.Retry = config.RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	submit := func(retry bool) api.QueryResponse {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+			"timeout":          "100ms",
+			"retry_on_timeout": retry,
+		})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+		// the callback is waited for until the deadline, not the callback timeout
+		var done api.QueryResponse
+		require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+		return done
+	}
+
+	done := submit(false)
+	assert.Equal(t, models.StatusTimedOut, done.Status)
+	assert.Contains(t, done.LastError, "timed out")
+	assert.Equal(t, 1, done.Attempts)
+
+	// the job is submitted again until attempts run out
+	done = submit(true)
+	assert.Equal(t, models.StatusTimedOut, done.Status)
+	assert.Equal(t, 2, done.Attempts)
+
+	// a callback coming after the deadline is too late
+	assert.Equal(t, http.StatusOK, callBack(router, callbackSecret, `{"job_id":"job-3","result":true}`).Code)
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+done.ID, nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusTimedOut, done.Status)
+}
+
+func TestCallbackOutlivesDefaultDeadline(t *testing.T) {
+	var callbackURL atomic.Value
+	cfg := callbackConfig(jobServer(t, &callbackURL).URL, time.Minute)
+	cfg.Queue.Timeout = 50 * time.Millisecond
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// the callback timeout of the provider applies, not the default deadline
+	require.Eventually(t, func() bool {
+		return callbackURL.Load() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, callBack(router, callbackSecret, `{"job_id":"job-1","result":true}`).Code)
+
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	assert.Equal(t, 1, done.Attempts)
+}
+
+// pausedStore is a store that holds every caller finding the request
+// waiting for a job in pause before it goes on
+type pausedStore struct {
+	repository.Store
+	pause func()
+}
+
+func (s *pausedStore) GetQueryByJob(ctx context.Context, provider, jobID string) (*models.Query, error) {
+	query, err := s.Store.GetQueryByJob(ctx, provider, jobID)
+	if err == nil {
+		s.pause()
+	}
+	return query, err
+}
+
+// outcomes is the terminal and retry events of a request published until
+// none comes for a while
+func outcomes(ch <-chan events.Event) []string {
+	var statuses []string
+	for {
+		select {
+		case event := <-ch:
+			if event.Status != models.StatusProcessing {
+				statuses = append(statuses, event.Status)
+			}
+		case <-time.After(200 * time.Millisecond):
+			return statuses
+		}
+	}
+}
+
+func TestDuplicateCallback(t *testing.T) {
+	var callbackURL atomic.Value
+	arrived := make(chan struct{}, 2)
+	resume := make(chan struct{})
+	store := &pausedStore{Store: memory.New(), pause: func() {
+		arrived <- struct{}{}
+		<-resume
+	}}
+	router, svc := newTestRouter(t, withConfig(callbackConfig(jobServer(t, &callbackURL).URL, time.Minute)), withStore(store))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	updates, unsubscribe := svc.Subscribe(created.ID)
+	defer unsubscribe()
+	require.Eventually(t, func() bool {
+		query, err := store.GetQueryByID(context.Background(), created.ID)
+		return err == nil && query.ExternalJobID == "job-1"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// both deliveries find the request waiting before either stores its verdict
+	codes := make(chan int, 2)
+	for _, body := range []string{`{"job_id":"job-1","result":true}`, `{"job_id":"job-1","result":false}`} {
+		go func() { codes <- callBack(router, callbackSecret, body).Code }()
+	}
+	<-arrived
+	<-arrived
+	close(resume)
+	assert.Equal(t, http.StatusOK, <-codes)
+	assert.Equal(t, http.StatusOK, <-codes)
+
+	// only the first verdict is stored and announced
+	assert.Equal(t, []string{models.StatusCompleted}, outcomes(updates))
+	query, err := store.GetQueryByID(context.Background(), created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, query.Status)
+}
+
+func TestCallbackRacingReclaim(t *testing.T) {
+	var callbackURL atomic.Value
+	var store *pausedStore
+	var id string
+	// the callback finds the request waiting, then its lease runs out and
+	// the worker gives up on the job before the verdict is stored
+	store = &pausedStore{Store: memory.New(), pause: func() {
+		require.Eventually(t, func() bool {
+			query, err := store.GetQueryByID(context.Background(), id)
+			return err == nil && query.Status == models.StatusDeadLetter
+		}, 5*time.Second, 10*time.Millisecond)
+	}}
+	router, svc := newTestRouter(t, withConfig(callbackConfig(jobServer(t, &callbackURL).URL, 50*time.Millisecond)), withStore(store))
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	id = created.ID
+	updates, unsubscribe := svc.Subscribe(id)
+	defer unsubscribe()
+	svc.Start(context.Background())
+	defer svc.Stop()
+	require.Eventually(t, func() bool {
+		query, err := store.GetQueryByID(context.Background(), id)
+		return err == nil && query.ExternalJobID == "job-1"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, http.StatusOK, callBack(router, callbackSecret, `{"job_id":"job-1","result":true}`).Code)
+
+	// the late verdict does not overwrite the outcome of the reclaim
+	assert.Equal(t, []string{models.StatusDeadLetter}, outcomes(updates))
+	query, err := store.GetQueryByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusDeadLetter, query.Status)
+	assert.Nil(t, query.Result)
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/models"
+)
+
+func TestCancelQuery(t *testing.T) {
+	received := make(chan struct{}, 1)
+	aborted := make(chan struct{}, 1)
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a disconnect is noticed once the body is read
+		io.ReadAll(r.Body)
+		received <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(5 * time.Second):
+			json.NewEncoder(w).Encode(map[string]bool{"result": true})
+		}
+	}))
+	defer external.Close()
+
+	router, svc := newTestRouter(t, withExternalServer(external.URL))
+	body := map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	}
+	submit := func() string {
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(doJSON(router, "POST", "/api/v1/query", body).Body.Bytes(), &created))
+		return created.ID
+	}
+
+	// a pending request never reaches the provider
+	pending := submit()
+	w := doJSON(router, "DELETE", "/api/v1/query/"+pending, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var cancelled api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cancelled))
+	assert.Equal(t, models.StatusCancelled, cancelled.Status)
+	assert.NotNil(t, cancelled.CompletedAt)
+
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	// a processing request has its call aborted
+	processing := submit()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider was not called")
+	}
+	w = doJSON(router, "DELETE", "/api/v1/query/"+processing, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call was not aborted")
+	}
+
+	// the worker leaves it cancelled
+	time.Sleep(50 * time.Millisecond)
+	var got api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+processing, nil).Body.Bytes(), &got))
+	assert.Equal(t, models.StatusCancelled, got.Status)
+	assert.Nil(t, got.Result)
+
+	assert.Equal(t, http.StatusConflict, doJSON(router, "DELETE", "/api/v1/query/"+processing, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "DELETE", "/api/v1/query/missing", nil).Code)
+}
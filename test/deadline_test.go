@@ -0,0 +1,182 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository/memory"
+)
+
+// hangingServer is an external server that answers after 5 seconds, or
+// never when the call is aborted before
+func hangingServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			json.NewEncoder(w).Encode(map[string]bool{"result": true})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestQueryDeadline(t *testing.T) {
+	cfg := testConfig(hangingServer(t).URL)
+	cfg.Queue.Timeout = 100 * time.Millisecond
+	cfg.Queue.MaxTimeout = time.Second
+	cfg.Retry = config.RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		attempts int
+	}{
+		{"default deadline", nil, 1},
+		{"own deadline", map[string]interface{}{"timeout": "200ms"}, 1},
+		{"retried", map[string]interface{}{"timeout": "50ms", "retry_on_timeout": true}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{
+				"cadastral_number": "77:01:0001001:1234",
+				"latitude":         55.75,
+				"longitude":        37.61,
+				"retry_on_timeout": false,
+			}
+			for k, v := range tt.fields {
+				body[k] = v
+			}
+
+			started := time.Now()
+			var created api.QueryResponse
+			require.NoError(t, json.Unmarshal(doJSON(router, "POST", "/api/v1/query", body).Body.Bytes(), &created))
+
+			var done api.QueryResponse
+			require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil).Body.Bytes(), &done))
+			assert.Equal(t, models.StatusTimedOut, done.Status)
+			assert.Contains(t, done.LastError, "timed out")
+			assert.Equal(t, tt.attempts, done.Attempts)
+			assert.Less(t, time.Since(started), 2*time.Second)
+
+			// calls cut by the deadline are attempts
+			var attempts []models.QueryAttempt
+			require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID+"/attempts", nil).Body.Bytes(), &attempts))
+			assert.Len(t, attempts, tt.attempts)
+		})
+	}
+}
+
+func TestQueryDeadlineValidation(t *testing.T) {
+	cfg := testConfig("http://localhost:0")
+	cfg.Queue.MaxTimeout = time.Minute
+	cfg.Queue.RetryOnTimeout = true
+	router, _ := newTestRouter(t, withConfig(cfg))
+
+	submit := func(fields map[string]interface{}) *httptest.ResponseRecorder {
+		body := map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return doJSON(router, "POST", "/api/v1/query", body)
+	}
+
+	for _, timeout := range []string{"soon", "-1s", "0s", "2m"} {
+		assert.Equal(t, http.StatusBadRequest, submit(map[string]interface{}{"timeout": timeout}).Code, timeout)
+	}
+
+	// the maximum is cut to the lease
+	cfg.Queue.MaxTimeout = 5 * time.Minute
+	cfg.Queue.LeaseDuration = 45 * time.Second
+	w := submit(map[string]interface{}{"timeout": "50s"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "timeout must not exceed 45s")
+
+	w = submit(map[string]interface{}{"timeout": "30s"})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "30s", created.Timeout)
+	assert.True(t, created.RetryOnTimeout)
+
+	w = submit(map[string]interface{}{"retry_on_timeout": false})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	created = api.QueryResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Empty(t, created.Timeout)
+	assert.False(t, created.RetryOnTimeout)
+
+	// both are kept with the request
+	var got api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID, nil).Body.Bytes(), &got))
+	assert.False(t, got.RetryOnTimeout)
+}
+
+func TestQueryTimeoutsCutToLease(t *testing.T) {
+	t.Setenv("QUEUE_LEASE_DURATION", "1m")
+	t.Setenv("QUERY_TIMEOUT", "90s")
+	t.Setenv("QUERY_MAX_TIMEOUT", "5m")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.Queue.Timeout)
+	assert.Equal(t, time.Minute, cfg.Queue.MaxTimeout)
+
+	t.Setenv("QUERY_TIMEOUT", "0s")
+	t.Setenv("QUERY_MAX_TIMEOUT", "30s")
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.Queue.Timeout)
+	assert.Equal(t, 30*time.Second, cfg.Queue.MaxTimeout)
+
+	// without a lease every request would be claimed by every worker at once
+	for _, lease := range []string{"0s", "-1m"} {
+		t.Setenv("QUEUE_LEASE_DURATION", lease)
+		_, err = config.Load()
+		assert.Error(t, err, lease)
+	}
+}
+
+func TestDeadlineWithinClaimLease(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig(hangingServer(t).URL)
+	cfg.Queue.LeaseDuration = time.Second
+	store := memory.New()
+	_, svc := newTestRouter(t, withConfig(cfg), withStore(store))
+
+	require.NoError(t, store.CreateQuery(ctx, testQuery("1", time.Now())))
+	claimed, err := store.ClaimQuery(ctx, cfg.Queue.LeaseDuration)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	require.NotNil(t, claimed.LockedUntil)
+
+	// the claim reaches processing late, the deadline still comes before
+	// the lease runs out rather than a whole timeout later
+	time.Sleep(400 * time.Millisecond)
+	svc.ProcessQuery(ctx, claimed)
+	assert.True(t, time.Now().Before(*claimed.LockedUntil))
+
+	done, err := store.GetQueryByID(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusTimedOut, done.Status)
+}
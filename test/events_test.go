@@ -0,0 +1,62 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"cadastral-service/internal/events"
+)
+
+func TestHubDeliversEventsOfSubscribedQuery(t *testing.T) {
+	hub := events.NewHub()
+
+	ch, unsubscribe := hub.Subscribe("q1")
+	defer unsubscribe()
+
+	hub.Publish(events.Event{QueryID: "q2", Status: "processing"})
+	hub.Publish(events.Event{QueryID: "q1", Status: "completed"})
+
+	select {
+	case event := <-ch:
+		assert.Equal(t, "q1", event.QueryID)
+		assert.Equal(t, "completed", event.Status)
+		assert.False(t, event.Time.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
+
+func TestHubUnsubscribeClosesChannel(t *testing.T) {
+	hub := events.NewHub()
+
+	ch, unsubscribe := hub.Subscribe("q1")
+	unsubscribe()
+	unsubscribe()
+
+	_, open := <-ch
+	assert.False(t, open)
+
+	// publishing after everybody left must not panic
+	hub.Publish(events.Event{QueryID: "q1", Status: "completed"})
+}
+
+func TestHubCloseEndsSubscriptions(t *testing.T) {
+	hub := events.NewHub()
+
+	ch, unsubscribe := hub.Subscribe("q1")
+	hub.Close()
+	unsubscribe()
+
+	_, open := <-ch
+	assert.False(t, open)
+
+	// subscribing after shutdown ends at once
+	late, unsubscribe := hub.Subscribe("q2")
+	defer unsubscribe()
+	_, open = <-late
+	assert.False(t, open)
+
+	hub.Publish(events.Event{QueryID: "q1", Status: "completed"})
+}
@@ -0,0 +1,193 @@
+package test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+
+	"cadastral-service/internal/api"
+)
+
+var exportHeader = []string{
+	"id", "cadastral_number", "latitude", "longitude", "status", "result",
+	"attempts", "last_error", "created_at", "completed_at",
+}
+
+// exportRouter is the API with one pending request in region 77 and one
+// cancelled request in region 50
+func exportRouter(t *testing.T) (*gin.Engine, string, string) {
+	router, _ := newTestRouter(t)
+
+	submit := func(number string, latitude float64) string {
+		var created api.QueryResponse
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": number,
+			"latitude":         latitude,
+			"longitude":        37.61,
+		})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created.ID
+	}
+
+	pending := submit("77:01:0001001:1234", 55.75)
+	cancelled := submit("50:01:0001001:1234", 55.5)
+	require.Equal(t, http.StatusOK, doJSON(router, "DELETE", "/api/v1/query/"+cancelled, nil).Code)
+	return router, pending, cancelled
+}
+
+// featureCollection is the GeoJSON export read back
+type featureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties api.QueryResponse `json:"properties"`
+	} `json:"features"`
+}
+
+func export(router *gin.Engine, query, accept string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/history/export"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestExportCSV(t *testing.T) {
+	router, pending, cancelled := exportRouter(t)
+
+	for _, accept := range []string{"", "text/csv"} {
+		w := export(router, "", accept)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Regexp(t, `^attachment; filename="history-\d{8}-\d{6}\.csv"$`, w.Header().Get("Content-Disposition"))
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, exportHeader, records[0])
+		ids := []string{records[1][0], records[2][0]}
+		assert.ElementsMatch(t, []string{pending, cancelled}, ids)
+	}
+
+	// filters of the history apply to the export
+	w := export(router, "?format=csv&status=cancelled", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, cancelled, records[1][0])
+	assert.Equal(t, "cancelled", records[1][4])
+	assert.Equal(t, "", records[1][5])
+	assert.NotEmpty(t, records[1][9])
+
+	w = export(router, "?format=csv&cadastral_prefix=77:", "")
+	records, err = csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, pending, records[1][0])
+	assert.Equal(t, "77:01:0001001:1234", records[1][1])
+	assert.Equal(t, "55.75", records[1][2])
+	assert.Equal(t, "37.61", records[1][3])
+	assert.Equal(t, "pending", records[1][4])
+
+	// complete segments of the prefix are normalized like stored numbers
+	w = export(router, "?format=csv&cadastral_prefix=77:1:1001:", "")
+	records, err = csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, pending, records[1][0])
+}
+
+func TestExportGeoJSON(t *testing.T) {
+	router, pending, _ := exportRouter(t)
+
+	for _, request := range [][2]string{{"?format=geojson", ""}, {"", "application/geo+json"}} {
+		w := export(router, request[0], request[1])
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/geo+json", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), ".geojson")
+
+		var collection featureCollection
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &collection))
+		assert.Equal(t, "FeatureCollection", collection.Type)
+		assert.Len(t, collection.Features, 2)
+	}
+
+	w := export(router, "?format=geojson&status=pending", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var collection featureCollection
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &collection))
+	require.Len(t, collection.Features, 1)
+	feature := collection.Features[0]
+	assert.Equal(t, "Feature", feature.Type)
+	assert.Equal(t, "Point", feature.Geometry.Type)
+	// GeoJSON points are longitude first
+	assert.Equal(t, []float64{37.61, 55.75}, feature.Geometry.Coordinates)
+	assert.Equal(t, pending, feature.Properties.ID)
+	assert.Equal(t, "pending", feature.Properties.Status)
+
+	// an empty history is still a collection
+	w = export(router, "?format=geojson&status=completed", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[]}`, w.Body.String())
+}
+
+func TestExportXLSX(t *testing.T) {
+	router, pending, cancelled := exportRouter(t)
+
+	mime := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	for _, request := range [][2]string{{"?format=xlsx", ""}, {"", mime}} {
+		w := export(router, request[0], request[1])
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, mime, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), ".xlsx")
+
+		f, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		rows, err := f.GetRows(f.GetSheetName(0))
+		f.Close()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, exportHeader, rows[0])
+		assert.ElementsMatch(t, []string{pending, cancelled}, []string{rows[1][0], rows[2][0]})
+	}
+
+	w := export(router, "?format=xlsx&cadastral_prefix=50:", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	f, err := excelize.OpenReader(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := f.GetRows(f.GetSheetName(0))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, cancelled, rows[1][0])
+}
+
+func TestExportRejected(t *testing.T) {
+	router, _, _ := exportRouter(t)
+
+	w := export(router, "?format=pdf", "")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Contains(t, w.Body.String(), "format must be csv, geojson or xlsx")
+
+	w = export(router, "", "application/pdf")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	w = export(router, "?format=csv&status=done", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,170 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+)
+
+func TestAggregate(t *testing.T) {
+	failed := errors.New("timeout")
+	yes := provider.Answer{Provider: "a", Result: true}
+	no := provider.Answer{Provider: "b", Result: false}
+	down := provider.Answer{Provider: "c", Err: failed}
+
+	tests := []struct {
+		name         string
+		strategy     string
+		answers      []provider.Answer
+		result       bool
+		disagreement bool
+		noConsensus  bool
+	}{
+		{"first wins", provider.StrategyFirstWins, []provider.Answer{down, no, yes}, false, true, false},
+		{"majority", provider.StrategyMajority, []provider.Answer{yes, no, yes}, true, true, false},
+		{"majority of asked", provider.StrategyMajority, []provider.Answer{yes, no, down}, false, true, true},
+		{"unanimous", provider.StrategyUnanimous, []provider.Answer{yes, no, yes}, false, true, false},
+		{"unanimous needs every answer", provider.StrategyUnanimous, []provider.Answer{yes, yes, down}, false, false, true},
+		{"unanimous decided by one false", provider.StrategyUnanimous, []provider.Answer{yes, down, no}, false, true, false},
+		{"all must agree", provider.StrategyAllMustAgree, []provider.Answer{no, no, no}, false, false, false},
+		{"all must answer", provider.StrategyAllMustAgree, []provider.Answer{yes, yes, down}, false, false, true},
+		{"nobody answered", provider.StrategyFirstWins, []provider.Answer{down, down, down}, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, disagreement, err := provider.Aggregate(tt.strategy, len(tt.answers), tt.answers)
+			assert.Equal(t, tt.result, result)
+			assert.Equal(t, tt.disagreement, disagreement)
+			if !tt.noConsensus {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, provider.ErrNoConsensus)
+			if tt.answers[2].Err != nil {
+				assert.ErrorIs(t, err, failed)
+			}
+		})
+	}
+
+	assert.True(t, provider.ValidStrategy(provider.StrategyMajority))
+	assert.False(t, provider.ValidStrategy("best-of-three"))
+}
+
+// verdictServer is a provider answering result, after delay
+func verdictServer(t *testing.T, result bool, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFanOutQuery(t *testing.T) {
+	cfg := testConfig("")
+	cfg.Providers = []config.ProviderConfig{
+		{Name: "a", URL: verdictServer(t, true, 0).URL},
+		{Name: "b", URL: verdictServer(t, true, 50*time.Millisecond).URL},
+		{Name: "c", URL: verdictServer(t, false, time.Second).URL},
+	}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	check := func(body map[string]interface{}) api.QueryResponse {
+		w := doJSON(router, "POST", "/api/v1/query", body)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+		var done api.QueryResponse
+		w = doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &done))
+		return done
+	}
+
+	// the slow provider disagrees, the majority decides
+	done := check(map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+		"providers":        []string{"a", "b", "c"},
+	})
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	assert.Equal(t, provider.StrategyMajority, done.Strategy)
+	assert.Empty(t, done.Provider)
+	if assert.NotNil(t, done.Result) {
+		assert.True(t, *done.Result)
+	}
+	assert.True(t, done.Disagreement)
+	require.Len(t, done.Answers, 3)
+	for _, a := range done.Answers {
+		require.NotNil(t, a.Result, a.Provider)
+		assert.Equal(t, a.Provider != "c", *a.Result)
+	}
+
+	// the first answer decides, the others are skipped
+	started := time.Now()
+	done = check(map[string]interface{}{
+		"cadastral_number": "77:01:0001001:5678",
+		"latitude":         55.75,
+		"longitude":        37.61,
+		"providers":        []string{"a", "c"},
+		"strategy":         provider.StrategyFirstWins,
+	})
+	assert.Less(t, time.Since(started), 800*time.Millisecond)
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	assert.False(t, done.Disagreement)
+	require.Len(t, done.Answers, 2)
+	for _, a := range done.Answers {
+		if a.Provider == "c" {
+			assert.Nil(t, a.Result)
+			assert.Contains(t, a.Error, "skipped")
+		}
+	}
+}
+
+func TestFanOutValidation(t *testing.T) {
+	cfg := testConfig("")
+	cfg.Providers = []config.ProviderConfig{
+		{Name: "a", URL: "http://a.example"},
+		{Name: "b", URL: "http://b.example"},
+	}
+	router, _ := newTestRouter(t, withConfig(cfg))
+
+	for _, extra := range []map[string]interface{}{
+		{"providers": []string{"a", "b"}, "provider": "a"},
+		{"providers": []string{"a", "a"}},
+		{"providers": []string{"a", "x"}},
+		{"providers": []string{"a", "b"}, "strategy": "best-of-three"},
+		{"strategy": provider.StrategyMajority},
+	} {
+		body := map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+		}
+		for k, v := range extra {
+			body[k] = v
+		}
+		w := doJSON(router, "POST", "/api/v1/query", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, extra)
+	}
+}
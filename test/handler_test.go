@@ -0,0 +1,337 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/cache"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/repository"
+	"cadastral-service/internal/repository/memory"
+	"cadastral-service/internal/service"
+)
+
+// testRouter is what newTestRouter builds the API from
+type testRouter struct {
+	cfg     *config.Config
+	results cache.Cache
+	broker  events.Broker
+	store   repository.Store
+}
+
+// routerOption is change one part of a test router
+type routerOption func(*testRouter)
+
+// withConfig is the given configuration instead of testConfig
+func withConfig(cfg *config.Config) routerOption {
+	return func(r *testRouter) { r.cfg = cfg }
+}
+
+// withExternalServer is the default provider at url
+func withExternalServer(url string) routerOption {
+	return func(r *testRouter) { r.cfg.ExternalServerURL = url }
+}
+
+// withResults is a result cache, results are kept for Cache.TTL
+func withResults(results cache.Cache) routerOption {
+	return func(r *testRouter) { r.results = results }
+}
+
+// withBroker is events published to broker instead of a hub of their own
+func withBroker(broker events.Broker) routerOption {
+	return func(r *testRouter) { r.broker = broker }
+}
+
+// withStore is the given store instead of an empty in-memory one
+func withStore(store repository.Store) routerOption {
+	return func(r *testRouter) { r.store = store }
+}
+
+// newTestRouter is the whole API over the in-memory store with testConfig,
+// options change any part of it
+func newTestRouter(t *testing.T, opts ...routerOption) (*gin.Engine, *service.Service) {
+	r := &testRouter{cfg: testConfig(""), broker: events.NewHub(), store: memory.New()}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	providers, err := provider.FromConfig(r.cfg)
+	require.NoError(t, err)
+
+	svc := service.NewService(r.store, r.broker, r.cfg, providers, r.results)
+	router := gin.New()
+	api.SetupRoutes(router, api.NewHandler(r.store, svc, r.cfg), r.cfg)
+	return router, svc
+}
+
+// testConfig is the configuration of test routers, the external server is the given URL
+func testConfig(externalServerURL string) *config.Config {
+	return &config.Config{
+		Environment:       "test",
+		Port:              "8080",
+		ExternalServerURL: externalServerURL,
+		Queue: config.QueueConfig{
+			Workers:       2,
+			PollInterval:  10 * time.Millisecond,
+			LeaseDuration: time.Minute,
+			MaxDepth:      100,
+		},
+		Retry: config.RetryConfig{MaxAttempts: 1},
+		Cache: config.CacheConfig{TTL: time.Minute},
+	}
+}
+
+func doJSON(router *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateAndGetQuery(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:1:1001:01234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var created api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "77:01:0001001:1234", created.CadastralNumber)
+	assert.Equal(t, models.StatusPending, created.Status)
+
+	w = doJSON(router, "GET", "/api/v1/query/"+created.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSON(router, "GET", "/api/v1/query/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doJSON(router, "GET", "/api/v1/history", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var history []api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	assert.Len(t, history, 1)
+}
+
+func TestCreateQueryValidation(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:abc:1",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "block")
+}
+
+func TestHistoryCursorPages(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	for i := 1; i <= 5; i++ {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:" + string(rune('0'+i)),
+			"latitude":         55.75,
+			"longitude":        37.61,
+		})
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	var first api.HistoryPage
+	w := doJSON(router, "GET", "/api/v1/history?cursor=&limit=2&include_total=true", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Len(t, first.Items, 2)
+	assert.Empty(t, first.PrevCursor)
+	assert.NotEmpty(t, first.NextCursor)
+	if assert.NotNil(t, first.Total) {
+		assert.Equal(t, 5, *first.Total)
+	}
+
+	var second api.HistoryPage
+	w = doJSON(router, "GET", "/api/v1/history?limit=2&cursor="+first.NextCursor, nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Len(t, second.Items, 2)
+	assert.NotEqual(t, first.Items[1].ID, second.Items[0].ID)
+
+	var back api.HistoryPage
+	w = doJSON(router, "GET", "/api/v1/history?limit=2&cursor="+second.PrevCursor, nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &back))
+	assert.Equal(t, first.Items, back.Items)
+}
+
+func TestQueryIsProcessed(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	router, svc := newTestRouter(t, withExternalServer(external.URL))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	w = doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil)
+	var done api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &done))
+	assert.Equal(t, models.StatusCompleted, done.Status)
+	if assert.NotNil(t, done.Result) {
+		assert.True(t, *done.Result)
+	}
+}
+
+func TestQueryAttemptsAreRecorded(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>bad gateway</html>"))
+	}))
+	defer external.Close()
+
+	router, svc := newTestRouter(t, withExternalServer(external.URL))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	var created api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	w = doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=5s", nil)
+	var done api.QueryResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &done))
+	assert.True(t, models.IsTerminalStatus(done.Status))
+
+	w = doJSON(router, "GET", "/api/v1/query/"+created.ID+"/attempts", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var attempts []models.QueryAttempt
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &attempts))
+	if assert.Len(t, attempts, 1) {
+		a := attempts[0]
+		assert.Equal(t, 1, a.Attempt)
+		assert.Equal(t, models.AttemptFailed, a.Status)
+		assert.Equal(t, "default", a.Provider)
+		assert.Equal(t, http.StatusBadGateway, a.StatusCode)
+		assert.JSONEq(t, `"<html>bad gateway</html>"`, string(a.Response))
+		assert.JSONEq(t, `{"cadastral_number":"77:01:0001001:1234","latitude":55.75,"longitude":37.61}`, string(a.Request))
+		assert.NotEmpty(t, a.Error)
+	}
+
+	w = doJSON(router, "GET", "/api/v1/query/missing/attempts", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCachedAndCoalescedQueries(t *testing.T) {
+	var calls atomic.Int32
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// slow enough for the second worker to join the call in flight
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	router, svc := newTestRouter(t, withExternalServer(external.URL), withResults(cache.NewLRU(10)))
+
+	body := map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	}
+	submit := func() string {
+		var created api.QueryResponse
+		assert.NoError(t, json.Unmarshal(doJSON(router, "POST", "/api/v1/query", body).Body.Bytes(), &created))
+		return created.ID
+	}
+	wait := func(id string) api.QueryResponse {
+		var done api.QueryResponse
+		assert.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+id+"?wait=5s", nil).Body.Bytes(), &done))
+		assert.Equal(t, models.StatusCompleted, done.Status)
+		return done
+	}
+
+	// both are claimed together, one call answers the two
+	first, second := submit(), submit()
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	a, b := wait(first), wait(second)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.True(t, a.Cached != b.Cached)
+
+	// later the result comes from the cache
+	third := wait(submit())
+	assert.True(t, third.Cached)
+	if assert.NotNil(t, third.Result) {
+		assert.True(t, *third.Result)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestLongPollEnds(t *testing.T) {
+	hub := events.NewHub()
+	router, _ := newTestRouter(t, withExternalServer("http://localhost:0"), withBroker(hub))
+
+	// no workers run, the request stays pending
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	}).Body.Bytes(), &created))
+
+	// a wait that times out answers with the current status
+	w := doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=50ms", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, models.StatusPending, got.Status)
+
+	// and one in progress returns when the server shuts down
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- doJSON(router, "GET", "/api/v1/query/"+created.ID+"?wait=60s", nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	hub.Close()
+
+	select {
+	case w = <-done:
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, models.StatusPending, got.Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the long poll did not return on shutdown")
+	}
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+)
+
+func TestHistoryRejectsInvalidFilters(t *testing.T) {
+	router := gin.New()
+	handler := &api.Handler{Config: &config.Config{Environment: "test"}}
+	router.GET("/api/v1/history", handler.GetHistory)
+
+	for _, query := range []string{
+		"status=done",
+		"result=maybe",
+		"created_from=yesterday",
+		"completed_to=2024-13-01",
+		"cadastral_prefix=77%25",
+		"cadastral_prefix=77::",
+		"cadastral_prefix=77:01:0001001:1:2",
+		"sort=password_hash",
+		"order=up",
+		"cursor=not-a-cursor",
+		"cursor=&sort=status",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/history?"+query, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
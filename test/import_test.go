@@ -0,0 +1,148 @@
+package test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+)
+
+// upload is POST a file to the import endpoint as multipart/form-data
+func upload(router *gin.Engine, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", filename)
+	part.Write(content)
+	mw.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/queries/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// importReport is the rows of the report of an import, without the header
+func importReport(t *testing.T, router *gin.Engine, batchID string) [][]string {
+	w := doJSON(router, "GET", "/api/v1/queries/import/"+batchID+"/report", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Equal(t, []string{"row", "cadastral_number", "latitude", "longitude", "status", "query_id", "error"}, records[0])
+	return records[1:]
+}
+
+func TestImportCSV(t *testing.T) {
+	router, _ := newTestRouter(t, withExternalServer("http://localhost:0"))
+
+	file := "cadastral_number;latitude;longitude\n" +
+		"77:01:0001001:1234;55,75;37,61\n" +
+		"77:01:0001001:1235;north;37.61\n" +
+		";;\n" +
+		"77:01:0001001:1236;95;37.61\n"
+	w := upload(router, "queries.csv", []byte(file))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var resp api.ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Accepted)
+	assert.Equal(t, 2, resp.Rejected)
+
+	report := importReport(t, router, resp.BatchID)
+	require.Len(t, report, 3)
+	assert.Equal(t, "2", report[0][0])
+	assert.Equal(t, "accepted", report[0][4])
+	assert.NotEmpty(t, report[0][5])
+	// the empty line is skipped, rows keep the numbers of the file
+	assert.Equal(t, "3", report[1][0])
+	assert.Equal(t, "rejected", report[1][4])
+	assert.Equal(t, "latitude is not a number", report[1][6])
+	assert.Equal(t, "5", report[2][0])
+	assert.Contains(t, report[2][6], "latitude must be between")
+
+	// the accepted row is a request of the batch
+	var progress api.BatchProgressResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/queries/batch/"+resp.BatchID, nil).Body.Bytes(), &progress))
+	assert.Equal(t, 1, progress.Total)
+}
+
+func TestImportReportEscapesFormulas(t *testing.T) {
+	router, _ := newTestRouter(t, withExternalServer("http://localhost:0"))
+
+	file := "cadastral_number;latitude;longitude\n" +
+		"=1+2;55.75;37.61\n" +
+		"77:01:0001001:1234;@SUM(1);-37.61\n" +
+		"77:01:0001001:1235;-1+cmd;+37.61\n"
+	w := upload(router, "queries.csv", []byte(file))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp api.ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	// cells a spreadsheet would run as formulas are quoted, numbers are not
+	report := importReport(t, router, resp.BatchID)
+	require.Len(t, report, 3)
+	assert.Equal(t, "'=1+2", report[0][1])
+	assert.Equal(t, []string{"'@SUM(1)", "-37.61"}, report[1][2:4])
+	assert.Equal(t, []string{"'-1+cmd", "+37.61"}, report[2][2:4])
+}
+
+func TestImportXLSX(t *testing.T) {
+	router, _ := newTestRouter(t, withExternalServer("http://localhost:0"))
+
+	content, err := os.ReadFile("testdata/import.xlsx")
+	require.NoError(t, err)
+	w := upload(router, "queries.xlsx", content)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var resp api.ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Accepted)
+	assert.Equal(t, 1, resp.Rejected)
+
+	report := importReport(t, router, resp.BatchID)
+	require.Len(t, report, 3)
+	assert.Equal(t, []string{"2", "accepted"}, []string{report[0][0], report[0][4]})
+	assert.Equal(t, []string{"3", "rejected"}, []string{report[1][0], report[1][4]})
+	assert.Equal(t, []string{"5", "accepted"}, []string{report[2][0], report[2][4]})
+}
+
+func TestImportRejected(t *testing.T) {
+	cfg := testConfig("http://localhost:0")
+	cfg.MaxUploadSize = 1024
+	router, _ := newTestRouter(t, withConfig(cfg))
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		code     int
+	}{
+		{"unknown format", "queries.pdf", "%PDF", http.StatusBadRequest},
+		{"missing columns", "queries.csv", "number,lat\n1,2\n", http.StatusBadRequest},
+		{"no data rows", "queries.csv", "cadastral_number,latitude,longitude\n", http.StatusBadRequest},
+		{"broken xlsx", "queries.xlsx", "not a zip", http.StatusBadRequest},
+		{"csv over the limit", "queries.csv", "cadastral_number,latitude,longitude\n" + strings.Repeat("77:01:0001001:1234,55.75,37.61\n", 100), http.StatusRequestEntityTooLarge},
+		{"xlsx over the limit", "queries.xlsx", strings.Repeat("x", 4096), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := upload(router, tt.filename, []byte(tt.content))
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+}
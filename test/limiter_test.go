@@ -0,0 +1,125 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/models"
+)
+
+func TestProviderLimits(t *testing.T) {
+	var active, peak atomic.Int32
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	cfg := testConfig("")
+	cfg.Queue.Workers = 4
+	cfg.Providers = []config.ProviderConfig{{
+		Name:        "registry",
+		URL:         external.URL,
+		LimitConfig: config.LimitConfig{RateLimit: 20, Burst: 1},
+	}}
+	cfg.Limits = config.LimitConfig{MaxInFlight: 1}
+	router, svc := newTestRouter(t, withConfig(cfg))
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:" + strconv.Itoa(1000+i),
+			"latitude":         55.75,
+			"longitude":        37.61,
+		})
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		ids = append(ids, created.ID)
+	}
+
+	started := time.Now()
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	// every request waits for its turn instead of failing
+	for _, id := range ids {
+		var done api.QueryResponse
+		require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+id+"?wait=5s", nil).Body.Bytes(), &done))
+		assert.Equal(t, models.StatusCompleted, done.Status)
+		assert.Equal(t, 1, done.Attempts)
+	}
+
+	// four calls at 20 per second with a burst of one take at least 150ms
+	assert.GreaterOrEqual(t, time.Since(started), 140*time.Millisecond)
+	assert.Equal(t, int32(1), peak.Load())
+
+	w := doJSON(router, "GET", "/metrics", nil)
+	assert.Contains(t, w.Body.String(), `cadastral_provider_calls_in_flight{provider="registry"} 0`)
+}
+
+func TestRateLimitedQueryIsHeld(t *testing.T) {
+	var calls atomic.Int32
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	cfg := testConfig(external.URL)
+	cfg.Queue.Workers = 1
+	cfg.Queue.Timeout = 5 * time.Second
+	// one call per minute
+	cfg.Limits = config.LimitConfig{RateLimit: 1.0 / 60, Burst: 1}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	submit := func(number string) string {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": number,
+			"latitude":         55.75,
+			"longitude":        37.61,
+		})
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created.ID
+	}
+
+	var done api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+submit("77:01:0001001:1000")+"?wait=5s", nil).Body.Bytes(), &done))
+	require.Equal(t, models.StatusCompleted, done.Status)
+
+	// the next turn comes after the deadline, the request is held for it at
+	// once instead of waiting out its deadline
+	started := time.Now()
+	id := submit("77:01:0001001:1001")
+	var held api.QueryResponse
+	require.Eventually(t, func() bool {
+		held = api.QueryResponse{}
+		json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+id, nil).Body.Bytes(), &held)
+		return held.Status == models.StatusPending && held.NextAttemptAt != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Less(t, time.Since(started), cfg.Queue.Timeout)
+	assert.Equal(t, 0, held.Attempts)
+	assert.WithinDuration(t, started.Add(time.Minute), *held.NextAttemptAt, 5*time.Second)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/repository"
+	"cadastral-service/migrations"
+	"cadastral-service/pkg/database"
+)
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count))
+	return count > 0
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewSQLite("sqlite://" + filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db, "sqlite")
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	assert.False(t, statuses[0].Applied)
+
+	require.NoError(t, migrator.Up(ctx))
+	// a second run has nothing to do
+	require.NoError(t, migrator.Up(ctx))
+	assert.True(t, tableExists(t, db, "queries"))
+
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, status.Name)
+		assert.False(t, status.Modified, status.Name)
+		assert.NotNil(t, status.AppliedAt)
+	}
+
+	var admins int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = 'admin'`).Scan(&admins))
+	assert.Equal(t, 1, admins)
+
+	require.NoError(t, migrator.Down(ctx, len(statuses)))
+	assert.False(t, tableExists(t, db, "queries"))
+	assert.True(t, tableExists(t, db, "schema_migrations"))
+
+	last := statuses[len(statuses)-1].Version
+	require.NoError(t, migrator.Goto(ctx, last))
+	assert.True(t, tableExists(t, db, "queries"))
+
+	assert.Error(t, migrator.Goto(ctx, last+1000))
+}
+
+func TestMigratorRejectsModifiedMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewSQLite("sqlite://" + filepath.Join(t.TempDir(), "migrate.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, database.Migrate(ctx, db, "sqlite"))
+
+	_, err = db.Exec(`UPDATE schema_migrations SET checksum = 'changed' WHERE version = 1`)
+	require.NoError(t, err)
+
+	migrator, err := database.NewMigrator(db, "sqlite")
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Modified)
+	assert.Error(t, migrator.Up(ctx))
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{"postgres", "sqlite"} {
+		list, err := database.LoadMigrations(migrations.FS, driver)
+		require.NoError(t, err, driver)
+		for i, mig := range list {
+			assert.Equal(t, i+1, mig.Version, driver)
+			assert.NotEmpty(t, mig.Down, "%s %s has no down file", driver, mig.Name)
+		}
+	}
+
+	_, err := database.LoadMigrations(fstest.MapFS{
+		"x/001_init.down.sql": {Data: []byte("DROP TABLE t;")},
+	}, "x")
+	assert.Error(t, err)
+}
+
+func TestMigrateNormalizesLegacyNumbers(t *testing.T) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	db, err := database.NewPostgres(databaseURL)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// rows stored before numbers were validated
+	migrator, err := database.NewMigrator(db, "postgres")
+	require.NoError(t, err)
+	require.NoError(t, migrator.Goto(ctx, 17))
+	_, err = db.Exec(`TRUNCATE queries CASCADE`)
+	require.NoError(t, err)
+	store := repository.NewRepository(db)
+	for id, number := range map[string]string{"legacy": " 7:1:1001:01234", "invalid": "not a number"} {
+		require.NoError(t, store.CreateQuery(ctx, testQuery(id, time.Now())))
+		_, err = db.Exec(`UPDATE queries SET cadastral_number = $1 WHERE id = $2`, number, id)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, migrator.Up(ctx))
+
+	queries, err := store.GetQueriesByCadastral(ctx, "07:01:0001001:1234", "")
+	require.NoError(t, err)
+	require.Len(t, queries, 1)
+	assert.Equal(t, "legacy", queries[0].ID)
+	invalid, err := store.GetQueryByID(ctx, "invalid")
+	require.NoError(t, err)
+	assert.Equal(t, "not a number", invalid.CadastralNumber)
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/provider"
+)
+
+func TestHTTPProviderMapping(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"data":{"valid":true}}`))
+	}))
+	defer server.Close()
+
+	p := provider.NewHTTPProvider(config.ProviderConfig{
+		Name:          "registry",
+		URL:           server.URL,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		RequestFields: map[string]string{"cadastral_number": "kn"},
+		ResultField:   "data.valid",
+	})
+
+	resp, err := p.Check(context.Background(), provider.Request{CadastralNumber: "77:01:0001001:1234", Latitude: 55.75, Longitude: 37.61})
+	require.NoError(t, err)
+	assert.True(t, resp.Result)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "77:01:0001001:1234", got["kn"])
+	assert.Equal(t, 55.75, got["latitude"])
+	assert.JSONEq(t, `{"data":{"valid":true}}`, string(resp.RawResponse))
+	assert.NotEmpty(t, resp.RawRequest)
+}
+
+func TestHTTPProviderErrors(t *testing.T) {
+	status, body := http.StatusOK, `{"result":"yes"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	p := provider.NewHTTPProvider(config.ProviderConfig{Name: "p", URL: server.URL})
+	_, err := p.Check(context.Background(), provider.Request{})
+	assert.ErrorContains(t, err, "not a boolean")
+
+	status = http.StatusServiceUnavailable
+	resp, err := p.Check(context.Background(), provider.Request{})
+	var statusErr *provider.StatusError
+	if assert.True(t, errors.As(err, &statusErr)) {
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	}
+	assert.Equal(t, body, string(resp.RawResponse))
+}
+
+func TestProviderRegistry(t *testing.T) {
+	registry, err := provider.FromConfig(&config.Config{Port: "8080"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default"}, registry.Names())
+
+	registry, err = provider.FromConfig(&config.Config{
+		Providers: []config.ProviderConfig{
+			{Name: "a", URL: "http://a.example"},
+			{Name: "b", URL: "http://b.example"},
+		},
+		DefaultProvider: "b",
+	})
+	require.NoError(t, err)
+	p, ok := registry.Get("")
+	require.True(t, ok)
+	assert.Equal(t, "b", p.Name())
+	_, ok = registry.Get("c")
+	assert.False(t, ok)
+
+	_, err = provider.FromConfig(&config.Config{Providers: []config.ProviderConfig{
+		{Name: "a", URL: "http://a.example"},
+		{Name: "a", URL: "http://b.example"},
+	}})
+	assert.Error(t, err)
+
+	_, err = provider.FromConfig(&config.Config{
+		Providers:       []config.ProviderConfig{{Name: "a", URL: "http://a.example"}},
+		DefaultProvider: "b",
+	})
+	assert.Error(t, err)
+}
+
+func TestProvidersFromEnv(t *testing.T) {
+	t.Setenv("PROVIDERS", `[{"name":"registry","url":"http://r.example","timeout":"30s","result_field":"data.valid","rate_limit":2.5,"max_in_flight":3}]`)
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 1)
+	assert.Equal(t, "registry", cfg.Providers[0].Name)
+	assert.Equal(t, "data.valid", cfg.Providers[0].ResultField)
+	assert.Equal(t, "30s", cfg.Providers[0].Timeout.String())
+	assert.Equal(t, 2.5, cfg.Providers[0].RateLimit)
+	assert.Equal(t, 3, cfg.Providers[0].MaxInFlight)
+
+	// a service silently left without its providers is worse than none starting
+	for _, value := range []string{
+		`[{"name":"registry","url":"http://r.example"}`,
+		`[{"name":"registry","url":"http://r.example","timeout":"30"}]`,
+		`[{"name":"async","url":"http://a.example","mode":"callback","callback_timeout":"ten minutes"}]`,
+	} {
+		t.Setenv("PROVIDERS", value)
+		_, err = config.Load()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestQueryRecordsProvider(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	body := map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	}
+	w := doJSON(router, "POST", "/api/v1/query", body)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "default", created.Provider)
+
+	body["provider"] = "unknown"
+	w = doJSON(router, "POST", "/api/v1/query", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/provider"
+	"cadastral-service/internal/repository/memory"
+	"cadastral-service/internal/service"
+)
+
+func TestQueueRecovery(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string][]time.Time{}
+	received := make(chan string, 4)
+	hang := true
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CadastralNumber string `json:"cadastral_number"`
+		}
+		// a disconnect is noticed once the body is read
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+
+		mu.Lock()
+		calls[req.CadastralNumber] = append(calls[req.CadastralNumber], time.Now())
+		first := hang
+		hang = false
+		mu.Unlock()
+		received <- req.CadastralNumber
+
+		// the first call is cut by the worker stopping
+		if first {
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	ctx := context.Background()
+	cfg := testConfig(external.URL)
+	cfg.Queue.Workers = 1
+	store := memory.New()
+	newService := func() *service.Service {
+		providers, err := provider.FromConfig(cfg)
+		require.NoError(t, err)
+		return service.NewService(store, events.NewHub(), cfg, providers, nil)
+	}
+
+	stopped := testQuery("1", time.Now())
+	require.NoError(t, store.CreateQuery(ctx, stopped))
+
+	// the service is stopped in the middle of a call
+	first := newService()
+	first.Start(ctx)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider was not called")
+	}
+	first.Stop()
+
+	query, err := store.GetQueryByID(ctx, stopped.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusPending, query.Status)
+	assert.Equal(t, 0, query.Attempts)
+
+	// a request claimed by a run that crashed is left alone until its lease expires
+	crashed := testQuery("2", time.Now().Add(-time.Hour))
+	require.NoError(t, store.CreateQuery(ctx, crashed))
+	const lease = 300 * time.Millisecond
+	claimed, err := store.ClaimQuery(ctx, lease)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	require.Equal(t, crashed.ID, claimed.ID)
+	claimedAt := time.Now()
+
+	second := newService()
+	second.Start(ctx)
+	defer second.Stop()
+
+	require.Eventually(t, func() bool {
+		query, err := store.GetQueryByID(ctx, stopped.ID)
+		return err == nil && query.Status == models.StatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the crashed request is taken once the lease expired
+	require.Eventually(t, func() bool {
+		query, err := store.GetQueryByID(ctx, crashed.ID)
+		return err == nil && query.Status == models.StatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, calls[stopped.CadastralNumber], 2, "the stopped call and the one that completed it")
+	require.Len(t, calls[crashed.CadastralNumber], 1, "a claimed request is not picked up twice")
+	assert.False(t, calls[crashed.CadastralNumber][0].Before(claimedAt.Add(lease)))
+
+	// the stopped call gave its attempt back, the crashed claim used one up
+	for id, want := range map[string]int{stopped.ID: 1, crashed.ID: 2} {
+		query, err := store.GetQueryByID(ctx, id)
+		require.NoError(t, err)
+		require.NotNil(t, query.Result)
+		assert.True(t, *query.Result)
+		assert.Equal(t, want, query.Attempts)
+
+		attempts, err := store.GetQueryAttempts(ctx, id)
+		require.NoError(t, err)
+		assert.Len(t, attempts, 1, "only the call that answered is logged")
+	}
+}
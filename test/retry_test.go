@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/models"
+)
+
+func TestQueryRetries(t *testing.T) {
+	var mu sync.Mutex
+	statuses := map[string]int{
+		"77:01:0001001:1234": http.StatusBadRequest,
+		"77:01:0001001:1235": http.StatusServiceUnavailable,
+	}
+	calls := map[string][]time.Time{}
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CadastralNumber string `json:"cadastral_number"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+
+		mu.Lock()
+		calls[req.CadastralNumber] = append(calls[req.CadastralNumber], time.Now())
+		status := statuses[req.CadastralNumber]
+		mu.Unlock()
+
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"result": true})
+	}))
+	defer external.Close()
+
+	const base = 150 * time.Millisecond
+	cfg := testConfig(external.URL)
+	cfg.Retry.MaxAttempts = 3
+	cfg.Retry.BaseDelay = base
+	cfg.Retry.MaxDelay = time.Second
+	cfg.Retry.RetryableStatusCodes = []int{http.StatusServiceUnavailable}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	submit := func(number string) string {
+		var created api.QueryResponse
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": number,
+			"latitude":         55.75,
+			"longitude":        37.61,
+		})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created.ID
+	}
+	get := func(id string) api.QueryResponse {
+		var query api.QueryResponse
+		require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+id, nil).Body.Bytes(), &query))
+		return query
+	}
+	waitStatus := func(id, status string) api.QueryResponse {
+		require.Eventually(t, func() bool {
+			return get(id).Status == status
+		}, 5*time.Second, 5*time.Millisecond)
+		return get(id)
+	}
+	callsTo := func(number string) []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), calls[number]...)
+	}
+
+	t.Run("client error is not retried", func(t *testing.T) {
+		query := waitStatus(submit("77:01:0001001:1234"), models.StatusFailed)
+		assert.Equal(t, 1, query.Attempts)
+		assert.Contains(t, query.LastError, "400")
+		assert.Len(t, callsTo("77:01:0001001:1234"), 1)
+	})
+
+	number := "77:01:0001001:1235"
+	id := submit(number)
+
+	t.Run("retryable error is retried with backoff", func(t *testing.T) {
+		var query api.QueryResponse
+		require.Eventually(t, func() bool {
+			query = get(id)
+			return query.Status == models.StatusPending && query.Attempts == 1
+		}, 5*time.Second, 5*time.Millisecond)
+		require.NotNil(t, query.NextAttemptAt)
+		first := callsTo(number)[0]
+		assert.WithinDuration(t, first.Add(base), *query.NextAttemptAt, base/2)
+		assert.Contains(t, query.LastError, "503")
+	})
+
+	t.Run("exhausted attempts are dead-lettered", func(t *testing.T) {
+		query := waitStatus(id, models.StatusDeadLetter)
+		assert.Equal(t, cfg.Retry.MaxAttempts, query.Attempts)
+
+		times := callsTo(number)
+		require.Len(t, times, cfg.Retry.MaxAttempts)
+		// the delay doubles with every attempt
+		assert.GreaterOrEqual(t, times[1].Sub(times[0]), base)
+		assert.GreaterOrEqual(t, times[2].Sub(times[1]), 2*base)
+	})
+
+	t.Run("requeue", func(t *testing.T) {
+		mu.Lock()
+		delete(statuses, number)
+		mu.Unlock()
+
+		w := doJSON(router, "POST", "/api/v1/query/"+id+"/requeue", nil)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		query := waitStatus(id, models.StatusCompleted)
+		assert.Equal(t, 1, query.Attempts)
+		require.NotNil(t, query.Result)
+		assert.True(t, *query.Result)
+
+		// only a dead-lettered, failed or timed out request goes back
+		w = doJSON(router, "POST", "/api/v1/query/"+id+"/requeue", nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = doJSON(router, "POST", "/api/v1/query/missing/requeue", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,483 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/repository"
+	"cadastral-service/internal/repository/memory"
+	"cadastral-service/pkg/database"
+)
+
+// stores is every Store implementation that runs without external services
+func stores(t *testing.T) map[string]repository.Store {
+	db, err := database.NewSQLite("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(context.Background(), db, "sqlite"))
+
+	return map[string]repository.Store{
+		"memory": memory.New(),
+		"sqlite": repository.NewSQLiteRepository(db),
+	}
+}
+
+func testQuery(id string, createdAt time.Time) *models.Query {
+	return &models.Query{
+		ID:              id,
+		CadastralNumber: "77:01:0001001:" + id,
+		Latitude:        55.75,
+		Longitude:       37.61,
+		Status:          models.StatusPending,
+		CreatedAt:       createdAt,
+	}
+}
+
+func TestStoreQueue(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			first := testQuery("1", base)
+			first.Timeout = 30 * time.Second
+			first.RetryOnTimeout = true
+			require.NoError(t, store.CreateQuery(ctx, first))
+			require.NoError(t, store.CreateQuery(ctx, testQuery("2", base.Add(time.Second))))
+
+			pending, err := store.CountPendingQueries(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, 2, pending)
+
+			claimed, err := store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, claimed)
+			assert.Equal(t, "1", claimed.ID)
+			assert.Equal(t, models.StatusProcessing, claimed.Status)
+			assert.Equal(t, 1, claimed.Attempts)
+			assert.Equal(t, 30*time.Second, claimed.Timeout)
+			assert.True(t, claimed.RetryOnTimeout)
+
+			// a retry in the future is not claimable yet
+			ok, err := store.RetryQuery(ctx, "1", "", "boom", time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			claimed, err = store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, claimed)
+			assert.Equal(t, "2", claimed.ID)
+
+			claimed, err = store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.Nil(t, claimed)
+
+			result := true
+			ok, err = store.UpdateQuery(ctx, "2", "", models.StatusCompleted, &result, false)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			done, err := store.GetQueryByID(ctx, "2")
+			require.NoError(t, err)
+			assert.Equal(t, models.StatusCompleted, done.Status)
+			require.NotNil(t, done.Result)
+			assert.True(t, *done.Result)
+			assert.NotNil(t, done.CompletedAt)
+			assert.NotNil(t, done.Cadastral)
+
+			// only a processing request takes an outcome
+			ok, err = store.FailQuery(ctx, "1", "", models.StatusDeadLetter, "boom")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, store.CreateQuery(ctx, testQuery("3", base.Add(2*time.Second))))
+			for _, status := range []string{models.StatusDeadLetter, models.StatusTimedOut} {
+				claimed, err = store.ClaimQuery(ctx, time.Minute)
+				require.NoError(t, err)
+				require.NotNil(t, claimed)
+				assert.Equal(t, "3", claimed.ID)
+				ok, err = store.FailQuery(ctx, "3", "", status, "boom")
+				require.NoError(t, err)
+				assert.True(t, ok)
+				ok, err = store.RequeueQuery(ctx, "3", "")
+				require.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, err = store.RequeueQuery(ctx, "2", "")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = store.RequeueQuery(ctx, "missing", "")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			_, err = store.GetQueryByID(ctx, "missing")
+			assert.True(t, errors.Is(err, repository.ErrNotFound))
+		})
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, id := range []string{"a", "b", "c", "d", "e"} {
+				require.NoError(t, store.CreateQuery(ctx, testQuery(id, base.Add(time.Duration(i)*time.Minute))))
+			}
+			// a is claimed on the way to b and given back
+			for i := 0; i < 2; i++ {
+				_, err := store.ClaimQuery(ctx, time.Minute)
+				require.NoError(t, err)
+			}
+			require.NoError(t, store.ReleaseQuery(ctx, "a", time.Time{}))
+			result := false
+			ok, err := store.UpdateQuery(ctx, "b", "", models.StatusCompleted, &result, false)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			filter := repository.QueryFilter{Desc: true}
+			queries, err := store.GetQueries(ctx, filter, 1, 2)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"e", "d"}, queryIDs(queries))
+
+			queries, err = store.GetQueries(ctx, repository.QueryFilter{
+				Statuses: []string{models.StatusCompleted},
+				Result:   repository.ResultFalse,
+			}, 1, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b"}, queryIDs(queries))
+
+			queries, err = store.GetQueries(ctx, repository.QueryFilter{
+				CreatedFrom: base.Add(time.Minute),
+				CreatedTo:   base.Add(3 * time.Minute),
+			}, 1, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b", "c"}, queryIDs(queries))
+
+			count, err := store.CountQueries(ctx, repository.QueryFilter{CadastralPrefix: "77:01"})
+			require.NoError(t, err)
+			assert.Equal(t, 5, count)
+
+			page, more, err := store.GetQueriesPage(ctx, filter, nil, 2)
+			require.NoError(t, err)
+			assert.True(t, more)
+			assert.Equal(t, []string{"e", "d"}, queryIDs(page))
+
+			last := page[len(page)-1]
+			page, more, err = store.GetQueriesPage(ctx, filter, &repository.Keyset{CreatedAt: last.CreatedAt, ID: last.ID}, 2)
+			require.NoError(t, err)
+			assert.True(t, more)
+			assert.Equal(t, []string{"c", "b"}, queryIDs(page))
+
+			first := page[0]
+			page, more, err = store.GetQueriesPage(ctx, filter, &repository.Keyset{CreatedAt: first.CreatedAt, ID: first.ID, Before: true}, 2)
+			require.NoError(t, err)
+			assert.False(t, more)
+			assert.Equal(t, []string{"e", "d"}, queryIDs(page))
+		})
+	}
+}
+
+func TestStoreUsersAndWebhooks(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := &models.User{ID: "u1", Username: "surveyor", PasswordHash: "x", CreatedAt: time.Now()}
+			require.NoError(t, store.CreateUser(ctx, user))
+			err := store.CreateUser(ctx, &models.User{ID: "u2", Username: "surveyor", PasswordHash: "x", CreatedAt: time.Now()})
+			assert.True(t, errors.Is(err, repository.ErrDuplicate))
+			require.NoError(t, store.UpdateUserWebhook(ctx, "u1", "http://example.com/hook", "secret"))
+
+			query := testQuery("q1", time.Now())
+			query.UserID = "u1"
+			require.NoError(t, store.CreateQuery(ctx, query))
+
+			delivery := &models.WebhookDelivery{
+				ID:        "d1",
+				QueryID:   "q1",
+				UserID:    "u1",
+				URL:       "http://example.com/hook",
+				Payload:   json.RawMessage(`{"event":"query.completed"}`),
+				Status:    models.DeliveryPending,
+				CreatedAt: time.Now().Add(-time.Second),
+			}
+			require.NoError(t, store.CreateWebhookDelivery(ctx, delivery))
+
+			claimed, err := store.ClaimWebhookDelivery(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, claimed)
+			assert.Equal(t, "secret", claimed.Secret)
+			assert.Equal(t, 1, claimed.Attempts)
+			assert.JSONEq(t, string(delivery.Payload), string(claimed.Payload))
+
+			claimed, err = store.ClaimWebhookDelivery(ctx, time.Minute)
+			require.NoError(t, err)
+			assert.Nil(t, claimed)
+
+			attempt := &models.WebhookAttempt{DeliveryID: "d1", Attempt: 1, StatusCode: 500, Error: "status 500", CreatedAt: time.Now()}
+			require.NoError(t, store.RecordWebhookAttempt(ctx, attempt, models.DeliveryFailed, time.Now()))
+
+			ok, err := store.RedeliverWebhook(ctx, "d1", "u1")
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			attempts, err := store.GetWebhookAttempts(ctx, "d1")
+			require.NoError(t, err)
+			assert.Len(t, attempts, 1)
+
+			deliveries, err := store.GetWebhookDeliveries(ctx, "u1", models.DeliveryPending, 10)
+			require.NoError(t, err)
+			assert.Len(t, deliveries, 1)
+		})
+	}
+}
+
+func TestStoreQueryAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			query := testQuery("q1", time.Now())
+			require.NoError(t, store.CreateQuery(ctx, query))
+
+			started := time.Now().Add(-time.Second)
+			require.NoError(t, store.RecordQueryAttempt(ctx, &models.QueryAttempt{
+				ID: "a1", QueryID: "q1", CadastralNumber: query.CadastralNumber, Attempt: 1,
+				Status: models.AttemptFailed, Request: json.RawMessage(`{"latitude":55.75}`),
+				StatusCode: 500, LatencyMs: 12, Error: "status 500", CreatedAt: started,
+			}))
+			require.NoError(t, store.RecordQueryAttempt(ctx, &models.QueryAttempt{
+				ID: "a2", QueryID: "q1", CadastralNumber: query.CadastralNumber, Attempt: 2,
+				Status: models.AttemptSucceeded, Request: json.RawMessage(`{"latitude":55.75}`),
+				Response: json.RawMessage(`{"result":true}`), StatusCode: 200, LatencyMs: 30, CreatedAt: time.Now(),
+			}))
+
+			attempts, err := store.GetQueryAttempts(ctx, "q1")
+			require.NoError(t, err)
+			require.Len(t, attempts, 2)
+			assert.Equal(t, "a1", attempts[0].ID)
+			assert.Equal(t, 500, attempts[0].StatusCode)
+			assert.Nil(t, attempts[0].Response)
+			assert.Equal(t, "status 500", attempts[0].Error)
+			assert.JSONEq(t, `{"result":true}`, string(attempts[1].Response))
+			assert.Equal(t, int64(30), attempts[1].LatencyMs)
+
+			attempts, err = store.GetQueryAttempts(ctx, "other")
+			require.NoError(t, err)
+			assert.Empty(t, attempts)
+		})
+	}
+}
+
+func TestStoreProviderAnswers(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			query := testQuery("q1", time.Now())
+			query.Providers = []string{"a", "b"}
+			query.Strategy = "majority"
+			require.NoError(t, store.CreateQuery(ctx, query))
+
+			yes := true
+			started := time.Now().Add(-time.Second)
+			require.NoError(t, store.SaveProviderAnswers(ctx, "q1", []models.ProviderAnswer{
+				{QueryID: "q1", Provider: "a", Result: &yes, Cached: true, LatencyMs: 5, CreatedAt: started},
+				{QueryID: "q1", Provider: "b", Error: "status 503", LatencyMs: 40, CreatedAt: started},
+			}, true))
+
+			answers, err := store.GetProviderAnswers(ctx, "q1")
+			require.NoError(t, err)
+			require.Len(t, answers, 2)
+			assert.Equal(t, "a", answers[0].Provider)
+			if assert.NotNil(t, answers[0].Result) {
+				assert.True(t, *answers[0].Result)
+			}
+			assert.True(t, answers[0].Cached)
+			assert.Nil(t, answers[1].Result)
+			assert.Equal(t, "status 503", answers[1].Error)
+
+			got, err := store.GetQueryByID(ctx, "q1")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, got.Providers)
+			assert.Equal(t, "majority", got.Strategy)
+			assert.True(t, got.Disagreement)
+
+			// the next round replaces the answers
+			require.NoError(t, store.SaveProviderAnswers(ctx, "q1", answers[:1], false))
+			answers, err = store.GetProviderAnswers(ctx, "q1")
+			require.NoError(t, err)
+			assert.Len(t, answers, 1)
+		})
+	}
+}
+
+func TestStoreEarlyCallbacks(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.TakeCallback(ctx, "async", "job-1")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+
+			old := &models.JobCallback{Provider: "async", JobID: "job-0", Result: true, ReceivedAt: time.Now().Add(-time.Hour)}
+			require.NoError(t, store.ParkCallback(ctx, old, time.Now().Add(-2*time.Hour)))
+			first := &models.JobCallback{Provider: "async", JobID: "job-1", Result: true, Body: []byte(`{"job_id":"job-1"}`), ReceivedAt: time.Now()}
+			require.NoError(t, store.ParkCallback(ctx, first, time.Now().Add(-2*time.Hour)))
+
+			// a later callback about the same job replaces the parked one,
+			// callbacks older than the expiry are dropped
+			second := &models.JobCallback{Provider: "async", JobID: "job-1", Error: "no such parcel", Body: []byte(`{"error":"no such parcel"}`), ReceivedAt: time.Now()}
+			require.NoError(t, store.ParkCallback(ctx, second, time.Now().Add(-time.Minute)))
+
+			_, err = store.TakeCallback(ctx, "other", "job-1")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+			cb, err := store.TakeCallback(ctx, "async", "job-1")
+			require.NoError(t, err)
+			assert.False(t, cb.Result)
+			assert.Equal(t, "no such parcel", cb.Error)
+			assert.Equal(t, second.Body, cb.Body)
+			assert.WithinDuration(t, second.ReceivedAt, cb.ReceivedAt, time.Millisecond)
+
+			// it is taken once
+			_, err = store.TakeCallback(ctx, "async", "job-1")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+			_, err = store.TakeCallback(ctx, "async", "job-0")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+		})
+	}
+}
+
+func TestStoreCallbackJobs(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			query := testQuery("q1", time.Now())
+			query.Provider = "async"
+			require.NoError(t, store.CreateQuery(ctx, query))
+
+			claimed, err := store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, claimed)
+			deadline := time.Now().Add(-time.Second)
+			require.NoError(t, store.AwaitCallback(ctx, "q1", "job-1", deadline, deadline))
+
+			waiting, err := store.GetQueryByJob(ctx, "async", "job-1")
+			require.NoError(t, err)
+			assert.Equal(t, "q1", waiting.ID)
+			_, err = store.GetQueryByJob(ctx, "other", "job-1")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+
+			// the wait ran out, the claim keeps the job and is not an attempt
+			claimed, err = store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, claimed)
+			assert.Equal(t, "job-1", claimed.ExternalJobID)
+			assert.Equal(t, 1, claimed.Attempts)
+			if assert.NotNil(t, claimed.DeadlineAt) {
+				assert.WithinDuration(t, deadline, *claimed.DeadlineAt, time.Millisecond)
+			}
+
+			// an outcome stored for another job is not applied
+			ok, err := store.RetryQuery(ctx, "q1", "job-2", "lost", time.Now())
+			require.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = store.RetryQuery(ctx, "q1", "job-1", "lost", time.Now())
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = store.RetryQuery(ctx, "q1", "job-1", "lost", time.Now())
+			require.NoError(t, err)
+			assert.False(t, ok)
+			_, err = store.GetQueryByJob(ctx, "async", "job-1")
+			assert.ErrorIs(t, err, repository.ErrNotFound)
+			retried, err := store.GetQueryByID(ctx, "q1")
+			require.NoError(t, err)
+			assert.Nil(t, retried.DeadlineAt)
+		})
+	}
+}
+
+func TestStoreCancelQuery(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.CreateUser(ctx, &models.User{ID: "u1", Username: "cartographer", PasswordHash: "x", CreatedAt: time.Now()}))
+			query := testQuery("q1", time.Now())
+			query.UserID = "u1"
+			require.NoError(t, store.CreateQuery(ctx, query))
+			_, err := store.ClaimQuery(ctx, time.Minute)
+			require.NoError(t, err)
+
+			ok, err := store.CancelQuery(ctx, "q1", "u2")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = store.CancelQuery(ctx, "q1", "u1")
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			// the worker finishing late does not overwrite it
+			result := true
+			ok, err = store.UpdateQuery(ctx, "q1", "", models.StatusCompleted, &result, false)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = store.RetryQuery(ctx, "q1", "", "boom", time.Now())
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			got, err := store.GetQueryByID(ctx, "q1")
+			require.NoError(t, err)
+			assert.Equal(t, models.StatusCancelled, got.Status)
+			assert.Nil(t, got.Result)
+
+			ok, err = store.CancelQuery(ctx, "q1", "")
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestStoreImport(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			batch := &models.Batch{ID: "b1", Total: 1, CreatedAt: time.Now()}
+			query := testQuery("q1", time.Now())
+			query.BatchID = batch.ID
+			report := []models.ImportRow{
+				{BatchID: "b1", Row: 3, CadastralNumber: "bad", Error: "cadastral number is malformed"},
+				{BatchID: "b1", Row: 2, CadastralNumber: query.CadastralNumber, QueryID: "q1"},
+			}
+			require.NoError(t, store.CreateImport(ctx, batch, []*models.Query{query}, report))
+
+			counts, err := store.CountBatchStatuses(ctx, "b1")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{models.StatusPending: 1}, counts)
+
+			rows, err := store.GetImportRows(ctx, "b1")
+			require.NoError(t, err)
+			if assert.Len(t, rows, 2) {
+				assert.Equal(t, 2, rows[0].Row)
+				assert.Equal(t, "q1", rows[0].QueryID)
+			}
+		})
+	}
+}
+
+func queryIDs(queries []models.Query) []string {
+	ids := make([]string, len(queries))
+	for i, q := range queries {
+		ids[i] = q.ID
+	}
+	return ids
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/events"
+	"cadastral-service/internal/models"
+	"cadastral-service/internal/service"
+)
+
+// streamServer is the API on a real server, streams need one, with a
+// provider answering after delay; workers start once a request is created
+func streamServer(t *testing.T, cfg *config.Config, delay time.Duration) (*httptest.Server, *gin.Engine, *service.Service) {
+	cfg.ExternalServerURL = verdictServer(t, true, delay).URL
+	router, svc := newTestRouter(t, withConfig(cfg))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, router, svc
+}
+
+// createQuery is submit a request and return its id
+func createQuery(t *testing.T, router *gin.Engine) string {
+	w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+		"cadastral_number": "77:01:0001001:1234",
+		"latitude":         55.75,
+		"longitude":        37.61,
+	})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var created api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created.ID
+}
+
+func TestQueryEventsSSE(t *testing.T) {
+	server, router, svc := streamServer(t, testConfig(""), 100*time.Millisecond)
+	id := createQuery(t, router)
+
+	resp, err := http.Get(server.URL + "/api/v1/query/" + id + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	// the stored status first, then every transition until the terminal one
+	// after which the server ends the stream
+	var statuses []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event events.Event
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		assert.Equal(t, id, event.QueryID)
+		statuses = append(statuses, event.Status)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{models.StatusPending, models.StatusProcessing, models.StatusCompleted}, statuses)
+
+	// a finished request gets its status and the end of the stream at once
+	w := doJSON(router, "GET", "/api/v1/query/"+id+"/events", nil)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "event:status"))
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", "/api/v1/query/missing/events", nil).Code)
+}
+
+func TestQueryEventsWS(t *testing.T) {
+	server, router, svc := streamServer(t, testConfig(""), 100*time.Millisecond)
+	id := createQuery(t, router)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/query/" + id + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	var statuses []string
+	for {
+		var event events.Event
+		if err := conn.ReadJSON(&event); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+			break
+		}
+		statuses = append(statuses, event.Status)
+	}
+	assert.Equal(t, []string{models.StatusPending, models.StatusProcessing, models.StatusCompleted}, statuses)
+}
+
+func TestQueryEventsWSOrigin(t *testing.T) {
+	cfg := testConfig("")
+	cfg.AllowedOrigins = []string{"https://app.example"}
+	server, router, _ := streamServer(t, cfg, 0)
+	id := createQuery(t, router)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/query/" + id + "/ws"
+
+	dial := func(origin string) (*http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+
+	// pages of other sites cannot read the events with the credentials of the user
+	resp, err := dial("https://evil.example")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	for _, origin := range []string{"", "https://app.example", server.URL} {
+		_, err := dial(origin)
+		assert.NoError(t, err, origin)
+	}
+}
+
+func TestLongPollReturnsTerminalStatus(t *testing.T) {
+	_, router, svc := streamServer(t, testConfig(""), 100*time.Millisecond)
+	id := createQuery(t, router)
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	// the poll is answered once the request is finished, not when it times out
+	started := time.Now()
+	w := doJSON(router, "GET", "/api/v1/query/"+id+"?wait=5s", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	var got api.QueryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, models.StatusCompleted, got.Status)
+
+	for _, wait := range []string{"soon", "-1s"} {
+		assert.Equal(t, http.StatusBadRequest, doJSON(router, "GET", "/api/v1/query/"+id+"?wait="+wait, nil).Code, wait)
+	}
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", "/api/v1/query/missing?wait=1s", nil).Code)
+}
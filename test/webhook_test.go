@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"cadastral-service/internal/api"
+	"cadastral-service/internal/config"
+	"cadastral-service/internal/service"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"query.completed"}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, service.SignWebhook("secret", 1700000000, body))
+	assert.NotEqual(t, expected, service.SignWebhook("other", 1700000000, body))
+	assert.NotEqual(t, expected, service.SignWebhook("secret", 1700000001, body))
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	cfg := testConfig(verdictServer(t, true, 0).URL)
+	cfg.Webhook = config.WebhookConfig{Workers: 2, AllowPrivateHosts: true, Timeout: time.Second, MaxAttempts: 1, PollInterval: 10 * time.Millisecond}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	submit := func() api.QueryResponse {
+		w := doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+			"callback_url":     receiver.URL,
+		})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var created api.QueryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created
+	}
+
+	// every request gets its own secret, shown once
+	created := submit()
+	require.Len(t, created.CallbackSecret, 64)
+	assert.NotEqual(t, created.CallbackSecret, submit().CallbackSecret)
+	var got api.QueryResponse
+	require.NoError(t, json.Unmarshal(doJSON(router, "GET", "/api/v1/query/"+created.ID, nil).Body.Bytes(), &got))
+	assert.Empty(t, got.CallbackSecret)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-received:
+			body := <-bodies
+			var payload service.WebhookPayload
+			require.NoError(t, json.Unmarshal(body, &payload))
+			if payload.Query.ID != created.ID {
+				continue
+			}
+			timestamp, err := strconv.ParseInt(r.Header.Get(service.WebhookTimestampHeader), 10, 64)
+			require.NoError(t, err)
+			assert.Equal(t, service.SignWebhook(created.CallbackSecret, timestamp, body), r.Header.Get(service.WebhookSignatureHeader))
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("the webhook was not delivered")
+		}
+	}
+	t.Fatal("no webhook about the request")
+}
+
+func TestCallbackURLValidation(t *testing.T) {
+	router, _ := newTestRouter(t, withExternalServer("http://localhost:0"))
+
+	submit := func(callbackURL string) int {
+		return doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+			"callback_url":     callbackURL,
+		}).Code
+	}
+
+	for _, callbackURL := range []string{
+		"ftp://example.com/hook",
+		"/hook",
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+	} {
+		assert.Equal(t, http.StatusBadRequest, submit(callbackURL), callbackURL)
+	}
+	assert.Equal(t, http.StatusAccepted, submit("https://example.com/hook"))
+}
+
+func TestPrivateHost(t *testing.T) {
+	assert.True(t, service.PrivateHost("localhost"))
+	assert.True(t, service.PrivateHost("api.localhost"))
+	assert.True(t, service.PrivateHost("172.16.0.1"))
+	assert.True(t, service.PrivateHost("fe80::1"))
+	assert.False(t, service.PrivateHost("example.com"))
+	assert.False(t, service.PrivateHost("93.184.216.34"))
+}
+
+func TestSlowWebhookDoesNotHoldOthers(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+	delivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer fast.Close()
+
+	cfg := testConfig(verdictServer(t, true, 0).URL)
+	cfg.Webhook = config.WebhookConfig{Workers: 2, AllowPrivateHosts: true, Timeout: 10 * time.Second, MaxAttempts: 1, PollInterval: 10 * time.Millisecond}
+	router, svc := newTestRouter(t, withConfig(cfg))
+	svc.Start(context.Background())
+	defer svc.Stop()
+
+	for _, callbackURL := range []string{slow.URL, fast.URL} {
+		require.Equal(t, http.StatusAccepted, doJSON(router, "POST", "/api/v1/query", map[string]interface{}{
+			"cadastral_number": "77:01:0001001:1234",
+			"latitude":         55.75,
+			"longitude":        37.61,
+			"callback_url":     callbackURL,
+		}).Code)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow endpoint held the other delivery")
+	}
+}